@@ -1,36 +1,151 @@
 package main
 
 import (
+	"flag"
+	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/roman-mazur/architecture-lab-3/painter"
+	"github.com/roman-mazur/architecture-lab-3/painter/journal"
 	"github.com/roman-mazur/architecture-lab-3/painter/lang"
+	"github.com/roman-mazur/architecture-lab-3/painter/record"
 	"github.com/roman-mazur/architecture-lab-3/ui"
+	"github.com/roman-mazur/architecture-lab-3/ui/offscreen"
 	"golang.org/x/exp/shiny/screen"
 )
 
+var (
+	addr      = flag.String("addr", "localhost:17000", "HTTP address to listen on")
+	headless  = flag.Bool("headless", false, "render offscreen without opening a window")
+	framesDir = flag.String("frames", "", "directory to write rendered frames to as PNG files (headless mode only)")
+	maxFPS    = flag.Int("fps", 0, "maximum frame rate, updates between frames are coalesced (0 renders every update)")
+	history   = flag.Int("history", 100, "number of steps kept for undo (0 disables undo)")
+
+	journalPath  = flag.String("journal", "", "file to record operations to and restore the canvas from on start")
+	compactEvery = flag.Int("compact", 1000, "number of journal records after which the journal is compacted (0 disables compaction)")
+
+	recordPath = flag.String("record", "", "record the session to a .gif file, or to a directory as a PNG sequence")
+	recordDir  = flag.String("record-dir", "recordings", "directory for recordings started with POST /record/start")
+)
+
 func main() {
-	var (
-		pv ui.Visualizer // Візуалізатор створює вікно та малює у ньому.
+	flag.Parse()
 
+	var (
 		// Потрібні для частини 2.
 		opLoop = painter.NewLoop() // Цикл обробки команд.
 		parser lang.Parser         // Парсер команд.
+		frames offscreen.FrameStore
 	)
 
+	opLoop.HistoryDepth = *history
+	var jrnl *journal.Journal
+	if *journalPath != "" {
+		j, s, err := journal.Open(*journalPath)
+		if err != nil {
+			log.Fatalf("Failed to open journal: %s", err)
+		}
+		defer j.Close()
+		j.CompactEvery = *compactEvery
+		opLoop.InitialState = s
+		opLoop.OnCommit = j.Commit
+		jrnl = j
+	}
+	if *recordPath != "" {
+		rec, err := record.New(*recordPath)
+		if err != nil {
+			log.Fatalf("Failed to start recording: %s", err)
+		}
+		// Цикл відписує отримувачів під час зупинки, тож запис вже повний.
+		defer func() {
+			if err := rec.Close(); err != nil {
+				log.Printf("Failed to finish recording: %s", err)
+			}
+		}()
+		if err := opLoop.Subscribe(rec, painter.LatestWins); err != nil {
+			log.Fatalf("Failed to start recording: %s", err)
+		}
+	}
+	if *maxFPS > 0 {
+		opLoop.FrameInterval = time.Second / time.Duration(*maxFPS)
+	}
+
+	http.Handle("/", lang.HttpHandler(opLoop, &parser))
+	http.Handle("GET /frame.png", lang.FrameHandler(&frames))
+	http.Handle("GET /state", lang.StateHandler(opLoop))
+	http.Handle("GET /scene.svg", lang.SceneHandler(opLoop))
+	http.Handle("POST /undo", lang.UndoHandler(opLoop))
+	http.Handle("POST /redo", lang.RedoHandler(opLoop))
+	http.Handle("GET /history", lang.HistoryHandler(opLoop, jrnl))
+	http.Handle("GET /history/{n}", lang.TimeTravelHandler(jrnl))
+	recordStart, recordStop := lang.RecordHandlers(opLoop, &frames, *recordDir)
+	http.Handle("POST /record/start", recordStart)
+	http.Handle("POST /record/stop", recordStop)
+
+	if *headless {
+		runHeadless(opLoop, &frames)
+	} else {
+		runWindow(opLoop, &frames)
+	}
+}
+
+func runWindow(opLoop *painter.Loop, frames *offscreen.FrameStore) {
+	var pv ui.Visualizer // Візуалізатор створює вікно та малює у ньому.
+
 	//pv.Debug = true
 	pv.Title = "Simple painter"
 
 	pv.OnScreenReady = func(s screen.Screen) {
-		go opLoop.Start(s)
+		// Дзеркало зберігає копію кожного кадру для /frame.png.
+		go startLoop(opLoop, offscreen.Mirror(s))
 	}
 	opLoop.Receiver = &pv
+	if err := opLoop.Subscribe(frames, painter.LatestWins); err != nil {
+		log.Fatalf("Failed to subscribe to frames: %s", err)
+	}
+	opLoop.OnError = logLoopError
 
 	go func() {
-		http.Handle("/", lang.HttpHandler(opLoop, &parser))
-		_ = http.ListenAndServe("localhost:17000", nil)
+		_ = http.ListenAndServe(*addr, nil)
 	}()
 
 	pv.Main()
 	opLoop.StopAndWait()
 }
+
+func runHeadless(opLoop *painter.Loop, frames *offscreen.FrameStore) {
+	if *framesDir != "" {
+		if err := os.MkdirAll(*framesDir, 0o755); err != nil {
+			log.Fatalf("Failed to create frames directory: %s", err)
+		}
+	}
+	frames.Dir = *framesDir
+	opLoop.Receiver = frames
+	opLoop.OnError = logLoopError
+
+	go startLoop(opLoop, offscreen.Screen{})
+
+	go func() {
+		log.Fatal(http.ListenAndServe(*addr, nil))
+	}()
+
+	// Без вікна зупиняємось лише за сигналом.
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+	opLoop.StopAndWait()
+}
+
+func startLoop(opLoop *painter.Loop, s screen.Screen) {
+	if err := opLoop.Start(s); err != nil {
+		log.Printf("Painter loop stopped: %s", err)
+	}
+}
+
+func logLoopError(err error) {
+	log.Printf("Painter loop error: %s", err)
+}
@@ -0,0 +1,64 @@
+package painter
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/colornames"
+)
+
+var ErrBadColor = errors.New("bad color")
+
+// ParseColor accepts #RRGGBB, #RRGGBBAA and CSS color names.
+func ParseColor(s string) (color.Color, error) {
+	if hex, ok := strings.CutPrefix(s, "#"); ok {
+		if len(hex) != 6 && len(hex) != 8 {
+			return nil, fmt.Errorf("%w: %s: want #RRGGBB or #RRGGBBAA", ErrBadColor, s)
+		}
+		v, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrBadColor, s)
+		}
+		if len(hex) == 6 {
+			v = v<<8 | 0xff
+		}
+		return color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+	}
+	if c, ok := colornames.Map[strings.ToLower(s)]; ok {
+		return c, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrBadColor, s)
+}
+
+// FormatColor writes the color as #RRGGBB, or as #RRGGBBAA if it is not opaque.
+func FormatColor(c color.Color) string {
+	if c == nil {
+		return "#00000000"
+	}
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	if n.A == 0xff {
+		return fmt.Sprintf("#%02x%02x%02x", n.R, n.G, n.B)
+	}
+	return fmt.Sprintf("#%02x%02x%02x%02x", n.R, n.G, n.B, n.A)
+}
+
+// jsonColor encodes colors as strings accepted by ParseColor.
+type jsonColor struct {
+	color.Color
+}
+
+func (c jsonColor) MarshalText() ([]byte, error) {
+	return []byte(FormatColor(c.Color)), nil
+}
+
+func (c *jsonColor) UnmarshalText(text []byte) error {
+	v, err := ParseColor(string(text))
+	if err != nil {
+		return err
+	}
+	c.Color = v
+	return nil
+}
@@ -0,0 +1,33 @@
+package painter
+
+import (
+	"errors"
+	"image/color"
+	"testing"
+)
+
+func TestParseColor(t *testing.T) {
+	cases := []struct {
+		in   string
+		want color.Color
+		err  error
+	}{
+		{in: "#ff8000", want: color.RGBA{R: 0xff, G: 0x80, A: 0xff}},
+		{in: "#FF800080", want: color.NRGBA{R: 0xff, G: 0x80, A: 0x80}},
+		{in: "white", want: color.White},
+		{in: "DarkOrange", want: color.RGBA{R: 0xff, G: 0x8c, A: 0xff}},
+		{in: "#ff80", err: ErrBadColor},
+		{in: "#gg0000", err: ErrBadColor},
+		{in: "notacolor", err: ErrBadColor},
+	}
+	for _, tc := range cases {
+		c, err := ParseColor(tc.in)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("ParseColor(%s) err = %v, want: %v", tc.in, err, tc.err)
+			continue
+		}
+		if tc.err == nil && !isColorsEqual(c, tc.want) {
+			t.Errorf("ParseColor(%s) = %v, want: %v", tc.in, c, tc.want)
+		}
+	}
+}
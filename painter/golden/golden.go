@@ -0,0 +1,166 @@
+// Package golden checks rendering in tests by comparing frames with
+// reference PNG files. Run the tests with -update to write the reference
+// files from the current results, for example:
+//
+//	go test ./painter -update
+package golden
+
+import (
+	"context"
+	"flag"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/roman-mazur/architecture-lab-3/painter"
+	"github.com/roman-mazur/architecture-lab-3/painter/lang"
+	"github.com/roman-mazur/architecture-lab-3/ui/offscreen"
+)
+
+var update = flag.Bool("update", false, "write golden files from the current results")
+
+// Dir is the directory with golden files, relative to the tested package.
+var Dir = "testdata"
+
+// Tolerance defines how different an image may be from its golden file.
+type Tolerance struct {
+	// Channel is the largest difference of a color channel, from 0 to 255,
+	// for pixels that are still considered equal.
+	Channel uint8
+	// Pixels is the number of different pixels allowed.
+	Pixels int
+}
+
+// DefaultTolerance allows small color differences, as from rounding, but
+// no different pixels.
+var DefaultTolerance = Tolerance{Channel: 2}
+
+// Render parses the script, applies it on a loop with an offscreen screen
+// and returns the last rendered frame.
+func Render(t testing.TB, script string) *image.RGBA {
+	t.Helper()
+	var p lang.Parser
+	ops, err := p.Parse(strings.NewReader(script))
+	if err != nil {
+		t.Fatalf("parse script: %s", err)
+	}
+
+	var frames offscreen.FrameStore
+	l := painter.NewLoop()
+	l.Receiver = &frames
+	go l.Start(offscreen.Screen{})
+	err = l.Apply(context.Background(), painter.OperationList(ops))
+	l.StopAndWait()
+	if err != nil {
+		t.Fatalf("apply script: %s", err)
+	}
+
+	img, n := frames.Latest()
+	if n == 0 {
+		t.Fatalf("script has not rendered any frame, it must have an update command")
+	}
+	return img
+}
+
+// AssertScript renders the script and compares the frame with the golden
+// file name.png with DefaultTolerance.
+func AssertScript(t testing.TB, name, script string) {
+	t.Helper()
+	Assert(t, name, Render(t, script), DefaultTolerance)
+}
+
+// Assert compares img with the golden file name.png in Dir. On failure the
+// image and a diff, with different pixels in red, are written to a
+// temporary directory.
+func Assert(t testing.TB, name string, img image.Image, tol Tolerance) {
+	t.Helper()
+	path := filepath.Join(Dir, name+".png")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := offscreen.WritePNG(path, img); err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("updated %s", path)
+		return
+	}
+
+	want, err := readPNG(path)
+	if os.IsNotExist(err) {
+		t.Fatalf("golden file %s does not exist, run the test with -update to create it", path)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want.Bounds() != img.Bounds() {
+		t.Fatalf("%s: image bounds: have: %v, want: %v", name, img.Bounds(), want.Bounds())
+	}
+
+	n, diff := Compare(want, img, tol)
+	if n <= tol.Pixels {
+		return
+	}
+	dir, err := os.MkdirTemp("", "golden-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	have := filepath.Join(dir, name+".png")
+	diffPath := filepath.Join(dir, name+".diff.png")
+	if err := offscreen.WritePNG(have, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := offscreen.WritePNG(diffPath, diff); err != nil {
+		t.Fatal(err)
+	}
+	t.Errorf("%s: %d pixels differ from %s, allowed %d\nimage: %s\ndiff: %s", name, n, path, tol.Pixels, have, diffPath)
+}
+
+// Compare returns the number of pixels of have that differ from want by more
+// than tol.Channel, and an image with them in red over a faded want. Both
+// images must have the same bounds.
+func Compare(want, have image.Image, tol Tolerance) (int, *image.RGBA) {
+	var (
+		n    int
+		b    = want.Bounds()
+		diff = image.NewRGBA(b)
+	)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			w := color.RGBAModel.Convert(want.At(x, y)).(color.RGBA)
+			h := color.RGBAModel.Convert(have.At(x, y)).(color.RGBA)
+			if channelDiff(w, h) > tol.Channel {
+				n++
+				diff.SetRGBA(x, y, color.RGBA{R: 0xff, A: 0xff})
+				continue
+			}
+			gray := color.GrayModel.Convert(w).(color.Gray)
+			v := 0xc0 + gray.Y/4 // Збігаючі пікселі світлі, щоб червоні було видно.
+			diff.SetRGBA(x, y, color.RGBA{v, v, v, 0xff})
+		}
+	}
+	return n, diff
+}
+
+func channelDiff(a, b color.RGBA) uint8 {
+	d := func(x, y uint8) uint8 {
+		if x > y {
+			return x - y
+		}
+		return y - x
+	}
+	return max(d(a.R, b.R), d(a.G, b.G), d(a.B, b.B), d(a.A, b.A))
+}
+
+func readPNG(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
@@ -0,0 +1,43 @@
+package golden
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	want := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	have := image.NewRGBA(want.Rect)
+	have.SetRGBA(1, 1, color.RGBA{R: 1})
+	have.SetRGBA(2, 2, color.RGBA{R: 0xff, A: 0xff})
+
+	cases := []struct {
+		tol  Tolerance
+		want int
+	}{
+		{Tolerance{}, 2},
+		{DefaultTolerance, 1},
+		{Tolerance{Channel: 0xff}, 0},
+	}
+	for _, tc := range cases {
+		n, diff := Compare(want, have, tc.tol)
+		if n != tc.want {
+			t.Errorf("tolerance %+v: have %d different pixels, want %d", tc.tol, n, tc.want)
+		}
+		if c := diff.RGBAAt(2, 2); n > 0 && c != (color.RGBA{R: 0xff, A: 0xff}) {
+			t.Errorf("tolerance %+v: diff pixel: have %v, want red", tc.tol, c)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	img := Render(t, "fill #0000ff\nbgrect 0 0 0.5 0.5 white\nupdate")
+	if c := img.RGBAAt(10, 10); c != (color.RGBA{0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("pixel in rect: have %v, want white", c)
+	}
+	if c := img.RGBAAt(700, 700); c != (color.RGBA{B: 0xff, A: 0xff}) {
+		t.Errorf("pixel outside rect: have %v, want blue", c)
+	}
+	Assert(t, "render", img, DefaultTolerance)
+}
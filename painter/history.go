@@ -0,0 +1,166 @@
+package painter
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strconv"
+)
+
+// history keeps the states saved at commit points for undo and redo.
+type history struct {
+	depth   int
+	current *State // Стан після останньої фіксації.
+	past    []*State
+	future  []*State
+}
+
+func (h *history) clone() history {
+	c := *h
+	c.past = slices.Clone(h.past)
+	c.future = slices.Clone(h.future)
+	return c
+}
+
+// commit saves s as a new history entry if it differs from the current one.
+func (h *history) commit(s *State) {
+	if h.current != nil && s.Equal(*h.current) {
+		return
+	}
+	if h.current != nil {
+		h.past = append(h.past, h.current)
+	}
+	h.current = s.Clone()
+	h.future = nil
+	h.trim()
+}
+
+// undo returns the state before the last commit. Changes made after the
+// last commit are committed first, so they can be redone.
+func (h *history) undo(s *State) (*State, bool) {
+	h.commit(s)
+	if len(h.past) == 0 {
+		return nil, false
+	}
+	h.future = append(h.future, h.current)
+	h.current = h.past[len(h.past)-1]
+	h.past = h.past[:len(h.past)-1]
+	return h.current.Clone(), true
+}
+
+// redo returns the state undone last. Changes made after that undo clear
+// the redo list.
+func (h *history) redo(s *State) (*State, bool) {
+	h.commit(s)
+	if len(h.future) == 0 {
+		return nil, false
+	}
+	h.past = append(h.past, h.current)
+	h.current = h.future[len(h.future)-1]
+	h.future = h.future[:len(h.future)-1]
+	h.trim()
+	return h.current.Clone(), true
+}
+
+func (h *history) setDepth(depth int) {
+	h.depth = max(depth, 0)
+	h.trim()
+}
+
+// trim drops the oldest entries beyond the depth.
+func (h *history) trim() {
+	if n := len(h.past) - h.depth; n > 0 {
+		h.past = slices.Delete(h.past, 0, n)
+	}
+	if n := len(h.future) - h.depth; n > 0 {
+		h.future = slices.Delete(h.future, 0, n)
+	}
+}
+
+// HistoryInfo describes the loop history.
+type HistoryInfo struct {
+	Depth int `json:"depth"`
+	Undo  int `json:"undo"` // Кількість кроків, які можна скасувати.
+	Redo  int `json:"redo"`
+}
+
+// History returns the number of steps that can be undone and redone.
+func (l *Loop) History(ctx context.Context) (HistoryInfo, error) {
+	var info HistoryInfo
+	err := l.query(ctx, func() {
+		info = HistoryInfo{l.history.depth, len(l.history.past), len(l.history.future)}
+	})
+	if err != nil {
+		return HistoryInfo{}, err
+	}
+	return info, nil
+}
+
+// UndoOp restores the state before the last commit. History operations are
+// handled by the loop and do nothing when applied to a State directly.
+type UndoOp struct{}
+
+var Undo = UndoOp{}
+
+func (op UndoOp) Do(s *State) (bool, error) { return false, nil }
+
+func (op UndoOp) String() string { return "undo" }
+
+func (op UndoOp) Equal(other Operation) bool {
+	_, ok := other.(UndoOp)
+	return ok
+}
+
+func (op UndoOp) MarshalJSON() ([]byte, error) { return marshalOp("undo", struct{}{}) }
+
+func (op *UndoOp) UnmarshalJSON(data []byte) error { return nil }
+
+// RedoOp restores the state undone last.
+type RedoOp struct{}
+
+var Redo = RedoOp{}
+
+func (op RedoOp) Do(s *State) (bool, error) { return false, nil }
+
+func (op RedoOp) String() string { return "redo" }
+
+func (op RedoOp) Equal(other Operation) bool {
+	_, ok := other.(RedoOp)
+	return ok
+}
+
+func (op RedoOp) MarshalJSON() ([]byte, error) { return marshalOp("redo", struct{}{}) }
+
+func (op *RedoOp) UnmarshalJSON(data []byte) error { return nil }
+
+// HistoryDepthOp changes the number of steps kept for undo and redo.
+type HistoryDepthOp struct {
+	Depth int
+}
+
+func SetHistoryDepth(depth int) HistoryDepthOp {
+	return HistoryDepthOp{depth}
+}
+
+func (op HistoryDepthOp) Do(s *State) (bool, error) { return false, nil }
+
+func (op HistoryDepthOp) String() string {
+	return "history " + strconv.Itoa(op.Depth)
+}
+
+func (op HistoryDepthOp) Equal(other Operation) bool {
+	o, ok := other.(HistoryDepthOp)
+	return ok && o == op
+}
+
+type jsonDepth struct {
+	Depth int `json:"depth"`
+}
+
+func (op HistoryDepthOp) MarshalJSON() ([]byte, error) {
+	return marshalOp("history", jsonDepth{op.Depth})
+}
+
+func (op *HistoryDepthOp) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, (*jsonDepth)(op))
+}
@@ -0,0 +1,258 @@
+// Package journal keeps an append-only file of the operations applied by
+// painter.Loop, so the canvas can be restored after a restart.
+package journal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/roman-mazur/architecture-lab-3/painter"
+)
+
+// Journal appends a record for every committed operation. Use Commit as
+// painter.Loop.OnCommit.
+type Journal struct {
+	// CompactEvery is the number of operation records after which Commit
+	// replaces the journal with a single record of the current state. Zero
+	// disables compaction.
+	CompactEvery int
+
+	// Sync makes Commit flush every record to the disk before returning.
+	Sync bool
+
+	path    string
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+	records int  // Операції після останнього знімка стану.
+	dirty   bool // Запис не вдався, тож наступним має бути повний стан.
+	now     func() time.Time
+}
+
+// Open opens the journal at path, creating it if needed, and replays it.
+// A torn or damaged last record, left by a crash, is cut off. A damaged
+// record followed by other records is an error, and the file is not changed.
+func Open(path string) (*Journal, *painter.State, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+	s, size, records, err := replay(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return &Journal{path: path, f: f, size: size, records: records, now: time.Now}, s, nil
+}
+
+// replay restores the state from the file and cuts off a broken tail. It
+// returns the state, the size of the valid records and the number of
+// operations after the last state record.
+func replay(f *os.File) (*painter.State, int64, int, error) {
+	var (
+		s       = painter.NewState()
+		r       = NewReader(f)
+		records int
+	)
+	for {
+		rec, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return s, r.Offset(), records, nil
+		}
+		if errors.Is(err, ErrTornRecord) || errors.Is(err, ErrCorrupt) && r.atEnd() {
+			log.Printf("Journal %s: %s, dropping the rest after %d bytes", f.Name(), err, r.Offset())
+			return s, r.Offset(), records, f.Truncate(r.Offset())
+		}
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("journal %s: %w", f.Name(), err)
+		}
+		if err := rec.Apply(s); err != nil {
+			return nil, 0, 0, fmt.Errorf("replay record at offset %d: %w", r.Offset(), err)
+		}
+		if rec.State != nil {
+			records = 0
+		} else {
+			records++
+		}
+	}
+}
+
+// Path returns the journal file name.
+func (j *Journal) Path() string {
+	return j.path
+}
+
+// Replay restores a state from the journal file as Replay does. It can be
+// called while the journal is in use.
+func (j *Journal) Replay(keep func(n int, rec Record) bool) (*painter.State, int, error) {
+	f, err := os.Open(j.path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	return Replay(f, keep)
+}
+
+// Replay applies records from r to a new state while keep returns true for
+// them, and returns the state and the number of applied records. Records
+// are numbered from 1. A torn or damaged record ends the replay like the
+// end of the journal, as it may be still being written.
+func Replay(r io.Reader, keep func(n int, rec Record) bool) (*painter.State, int, error) {
+	var (
+		s  = painter.NewState()
+		rd = NewReader(r)
+	)
+	for n := 1; ; n++ {
+		rec, err := rd.Next()
+		if errors.Is(err, io.EOF) || errors.Is(err, ErrTornRecord) || errors.Is(err, ErrCorrupt) {
+			return s, n - 1, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if !keep(n, rec) {
+			return s, n - 1, nil
+		}
+		if err := rec.Apply(s); err != nil {
+			return nil, 0, fmt.Errorf("replay record %d: %w", n, err)
+		}
+	}
+}
+
+// Commit appends the operation to the journal. Operations that cannot be
+// replayed from the journal alone, such as Undo or custom operations, are
+// written as the state s after them. After a failed write the next commit
+// is written as a state too, so no operation is missing on replay.
+func (j *Journal) Commit(op painter.Operation, s *painter.State) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	rec := Record{Time: j.now(), Op: op}
+	if j.dirty || !replayable(op) {
+		rec = Record{Time: rec.Time, State: s}
+	}
+	data, err := encode(rec)
+	if err != nil {
+		rec = Record{Time: rec.Time, State: s}
+		if data, err = encode(rec); err != nil {
+			return err
+		}
+	}
+	if err := j.write(data); err != nil {
+		return err
+	}
+
+	if rec.State != nil {
+		j.records = 0
+	} else {
+		j.records++
+	}
+	if j.CompactEvery > 0 && j.records >= j.CompactEvery {
+		return j.compact(s)
+	}
+	return nil
+}
+
+func (j *Journal) write(data []byte) error {
+	if j.f == nil {
+		return os.ErrClosed
+	}
+	if _, err := j.f.Write(data); err != nil {
+		// Частково записаний запис зрізається, щоб після нього можна було писати далі.
+		j.f.Truncate(j.size)
+		j.dirty = true
+		return err
+	}
+	j.size += int64(len(data))
+	if j.Sync {
+		if err := j.f.Sync(); err != nil {
+			j.dirty = true
+			return err
+		}
+	}
+	j.dirty = false
+	return nil
+}
+
+// replayable reports whether the operation gives the same result when it is
+// applied to the state restored from the previous records. Only operations
+// that painter.UnmarshalOperation can read back are replayable, anything
+// else, such as a custom operation type, is written as a state.
+func replayable(op painter.Operation) bool {
+	switch op := painter.Unprioritized(op).(type) {
+	case painter.OperationList:
+		for _, o := range op {
+			if !replayable(o) {
+				return false
+			}
+		}
+		return true
+	case painter.UpdateOp, painter.FillOp, painter.BgRectOp, painter.RemoveBgRectOp,
+		painter.ClearBgRectsOp, painter.FigureOp, painter.MoveOp, painter.ShiftOp,
+		painter.RemoveFigureOp, painter.MoveAllOp, painter.ResetOp, painter.HistoryDepthOp:
+		return true
+	}
+	// Undo та Redo залежать від історії, якої немає в журналі.
+	return false
+}
+
+// Compact replaces the journal with a single record of the state s.
+func (j *Journal) Compact(s *painter.State) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.compact(s)
+}
+
+func (j *Journal) compact(s *painter.State) error {
+	if j.f == nil {
+		return os.ErrClosed
+	}
+	data, err := encode(Record{Time: j.now(), State: s})
+	if err != nil {
+		return err
+	}
+
+	// Новий файл замінює старий лише після повного запису.
+	tmp := j.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := syncFile(tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, j.path); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(j.path, os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	j.f.Close()
+	j.f, j.size, j.records, j.dirty = f, int64(len(data)), 0, false
+	return nil
+}
+
+func syncFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f == nil {
+		return nil
+	}
+	err := j.f.Close()
+	j.f = nil
+	return err
+}
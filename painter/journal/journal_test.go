@@ -0,0 +1,275 @@
+package journal
+
+import (
+	"bytes"
+	"errors"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/roman-mazur/architecture-lab-3/painter"
+	"github.com/roman-mazur/architecture-lab-3/ui/offscreen"
+)
+
+func openJournal(t *testing.T, path string) (*Journal, *painter.State) {
+	t.Helper()
+	j, s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { j.Close() })
+	return j, s
+}
+
+func TestReplayAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "painter.journal")
+	j, s := openJournal(t, path)
+
+	l := painter.NewLoop()
+	l.InitialState = s
+	l.OnCommit = j.Commit
+	go l.Start(offscreen.Screen{})
+	l.Post(painter.OperationList{painter.WhiteFill, painter.NamedFigure("a", painter.Pt(0.5, 0.5)), painter.Update})
+	l.Post(painter.OperationList{painter.NamedBgRect("r", painter.Rect(0, 0, 0.5, 0.5), color.RGBA{R: 0xff, A: 0xff}), painter.Update})
+	l.Post(painter.Undo)
+	l.Post(painter.OperationList{painter.Move("a", painter.Pt(0.1, 0.2)), painter.Update})
+	l.Post(painter.RemoveFigure("missing")) // Невдала операція не потрапляє в журнал.
+	want, err := l.Snapshot(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.StopAndWait()
+	j.Close()
+
+	_, restored := openJournal(t, path)
+	if !restored.Equal(*want.State()) {
+		t.Errorf("restored state: have: %+v, want: %+v", restored, want.State())
+	}
+
+	l = painter.NewLoop()
+	l.InitialState = restored
+	go l.Start(offscreen.Screen{})
+	snap, _ := l.Snapshot(t.Context())
+	l.StopAndWait()
+	if f := snap.Figures(); len(f) != 1 || f[0].Pos != painter.Pt(0.1, 0.2) {
+		t.Errorf("loop did not start from the restored state: figures %v", f)
+	}
+}
+
+func TestTornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "painter.journal")
+	j, s := openJournal(t, path)
+	for _, op := range []painter.Operation{painter.GreenFill, painter.NamedFigure("a", painter.Pt(0.3, 0.3))} {
+		if _, err := op.Do(s); err != nil {
+			t.Fatal(err)
+		}
+		if err := j.Commit(op, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	j.Close()
+	good, _ := os.Stat(path)
+
+	// Запис, обірваний посередині, як після збою.
+	data, _ := encode(Record{Op: painter.Reset})
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	f.Write(data[:len(data)-3])
+	f.Close()
+
+	j, restored := openJournal(t, path)
+	if !restored.Equal(*s) {
+		t.Errorf("restored state: have: %+v, want: %+v", restored, s)
+	}
+	if fi, _ := os.Stat(path); fi.Size() != good.Size() {
+		t.Errorf("journal size: have: %d, want: %d", fi.Size(), good.Size())
+	}
+
+	if err := j.Commit(painter.Reset, painter.NewState()); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+	_, restored = openJournal(t, path)
+	if !restored.Equal(*painter.NewState()) {
+		t.Errorf("record after the cut is lost: %+v", restored)
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	a, _ := encode(Record{Op: painter.WhiteFill})
+	b, _ := encode(Record{Op: painter.Update})
+
+	r := NewReader(bytes.NewReader(append(a, b[:5]...)))
+	if _, err := r.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); !errors.Is(err, ErrTornRecord) {
+		t.Errorf("torn header: err = %v, want: %v", err, ErrTornRecord)
+	}
+	if r.Offset() != int64(len(a)) {
+		t.Errorf("offset: have: %d, want: %d", r.Offset(), len(a))
+	}
+
+	damaged := bytes.Clone(b)
+	damaged[len(damaged)-2] ^= 0xff
+	r = NewReader(bytes.NewReader(append(bytes.Clone(a), damaged...)))
+	r.Next()
+	if _, err := r.Next(); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("damaged record: err = %v, want: %v", err, ErrCorrupt)
+	}
+
+	r = NewReader(bytes.NewReader(a))
+	r.Next()
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("end of journal: err = %v, want: %v", err, io.EOF)
+	}
+}
+
+func TestCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "painter.journal")
+	j, s := openJournal(t, path)
+	j.CompactEvery = 4
+
+	for i := range 10 {
+		op := painter.NamedFigure("a", painter.Pt(float32(i)/10, 0))
+		op.Do(s)
+		if err := j.Commit(op, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Запустимо користувацьку операцію, яку можна записати лише як стан.
+	custom := painter.OperationFunc(func(s *painter.State) error {
+		s.SetBackground(color.White)
+		return nil
+	})
+	// Структура кодується в JSON, але без назви операції, тож теж пишеться як стан.
+	ops := []painter.Operation{
+		custom,
+		greenBackground{Note: "custom"},
+		painter.OperationList{painter.WithPriority(painter.NamedFigure("b", painter.Pt(0.5, 0.5)), painter.PriorityBulk)},
+	}
+	for _, op := range ops {
+		op.Do(s)
+		if err := j.Commit(op, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	j.Close()
+
+	f, _ := os.Open(path)
+	defer f.Close()
+	var kinds []bool
+	r := NewReader(f)
+	for {
+		rec, err := r.Next()
+		if err != nil {
+			break
+		}
+		kinds = append(kinds, rec.State != nil)
+	}
+	// Після двох ущільнень лишаються знімок, дві операції, два знімки та список.
+	if want := []bool{true, false, false, true, true, false}; !slices.Equal(kinds, want) {
+		t.Errorf("records (true for states): have: %v, want: %v", kinds, want)
+	}
+
+	_, restored := openJournal(t, path)
+	if !restored.Equal(*s) {
+		t.Errorf("restored state: have: %+v, want: %+v", restored, s)
+	}
+}
+
+func TestStateRecordKeepsFigureSeq(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "painter.journal")
+	j, s := openJournal(t, path)
+	j.CompactEvery = 1 // Кожен запис стає знімком стану.
+	for _, op := range []painter.Operation{painter.Figure(painter.Pt(0, 0)), painter.RemoveFigure("f1")} {
+		op.Do(s)
+		if err := j.Commit(op, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	j.Close()
+
+	_, restored := openJournal(t, path)
+	if id := restored.AddFigure(painter.FigureItem{}); id != "f2" {
+		t.Errorf("automatic ID after restart: have: %s, want: f2", id)
+	}
+}
+
+// greenBackground is an operation type the journal cannot decode.
+type greenBackground struct {
+	Note string
+}
+
+func (op greenBackground) Do(s *painter.State) (bool, error) {
+	s.SetBackground(color.RGBA{G: 0xff, A: 0xff})
+	return false, nil
+}
+
+func TestCorruptRecordInTheMiddle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "painter.journal")
+	j, s := openJournal(t, path)
+	for _, op := range []painter.Operation{painter.GreenFill, painter.NamedFigure("a", painter.Pt(0.3, 0.3)), painter.Reset} {
+		op.Do(s)
+		if err := j.Commit(op, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	j.Close()
+
+	data, _ := os.ReadFile(path)
+	r := NewReader(bytes.NewReader(data))
+	r.Next()
+	damaged := bytes.Clone(data)
+	damaged[r.Offset()+headerSize+2] ^= 0xff // Другий запис із трьох.
+	os.WriteFile(path, damaged, 0o644)
+
+	if _, _, err := Open(path); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("open: err = %v, want: %v", err, ErrCorrupt)
+	}
+	if have, _ := os.ReadFile(path); !bytes.Equal(have, damaged) {
+		t.Errorf("journal was changed: %d bytes, want: %d", len(have), len(damaged))
+	}
+
+	// Пошкоджений останній запис зрізається.
+	damaged = bytes.Clone(data)
+	damaged[len(damaged)-2] ^= 0xff
+	os.WriteFile(path, damaged, 0o644)
+	_, restored := openJournal(t, path)
+	if f := restored.Figures(); len(f) != 1 || f[0].ID != "a" {
+		t.Errorf("restored figures: have: %v, want: [a]", f)
+	}
+}
+
+func TestCommitAfterFailedWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "painter.journal")
+	j, s := openJournal(t, path)
+	commit := func(op painter.Operation) error {
+		if _, err := op.Do(s); err != nil {
+			t.Fatal(err)
+		}
+		return j.Commit(op, s)
+	}
+
+	if err := commit(painter.NamedFigure("a", painter.Pt(0.1, 0.1))); err != nil {
+		t.Fatal(err)
+	}
+	f := j.f
+	j.f, _ = os.Open(path) // Запис у файл, відкритий лише для читання, не вдасться.
+	if err := commit(painter.NamedFigure("b", painter.Pt(0.2, 0.2))); err == nil {
+		t.Fatal("write to a read-only file succeeded")
+	}
+	j.f.Close()
+	j.f = f
+	if err := commit(painter.Move("b", painter.Pt(0.5, 0.5))); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+
+	_, restored := openJournal(t, path)
+	if !restored.Equal(*s) {
+		t.Errorf("restored state: have: %+v, want: %+v", restored, s)
+	}
+}
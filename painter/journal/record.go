@@ -0,0 +1,144 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+
+	"github.com/roman-mazur/architecture-lab-3/painter"
+)
+
+// Кожен запис: довжина (4 байти), CRC32 вмісту (4 байти), вміст у JSON.
+const (
+	headerSize    = 8
+	maxRecordSize = 16 << 20
+)
+
+var (
+	ErrTornRecord = errors.New("journal record is incomplete")
+	ErrCorrupt    = errors.New("journal record is corrupt")
+)
+
+// Record is an operation or a full state written to the journal.
+// Exactly one of Op and State is set.
+type Record struct {
+	Time  time.Time
+	Op    painter.Operation
+	State *painter.State
+}
+
+// Apply changes s as the recorded operation did, or replaces it with the
+// recorded state.
+func (r Record) Apply(s *painter.State) error {
+	if r.State != nil {
+		*s = *r.State.Clone()
+		return nil
+	}
+	_, err := r.Op.Do(s)
+	return err
+}
+
+type jsonRecord struct {
+	Time  time.Time       `json:"time"`
+	Op    json.RawMessage `json:"op,omitempty"`
+	State *painter.State  `json:"state,omitempty"`
+	// Лічильник автоматичних ID фігур не входить у JSON стану.
+	FigureSeq int `json:"figureSeq,omitempty"`
+}
+
+func encode(r Record) ([]byte, error) {
+	v := jsonRecord{Time: r.Time, State: r.State}
+	if r.State != nil {
+		v.FigureSeq = r.State.FigureSeq()
+	}
+	if r.Op != nil {
+		op, err := json.Marshal(r.Op)
+		if err != nil {
+			return nil, err
+		}
+		v.Op = op
+	}
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, headerSize, headerSize+len(payload))
+	binary.BigEndian.PutUint32(buf, uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(payload))
+	return append(buf, payload...), nil
+}
+
+// Reader reads records written by Journal.
+type Reader struct {
+	r   *bufio.Reader
+	off int64
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next record or io.EOF after the last one. A record cut
+// off by a crash gives ErrTornRecord, a damaged one gives ErrCorrupt; the
+// reader must not be used after them.
+func (r *Reader) Next() (Record, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r.r, header[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return Record{}, ErrTornRecord
+		}
+		return Record{}, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > maxRecordSize {
+		return Record{}, fmt.Errorf("%w: size %d at offset %d", ErrCorrupt, size, r.off)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r.r, payload); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return Record{}, ErrTornRecord
+		}
+		return Record{}, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+		return Record{}, fmt.Errorf("%w: checksum mismatch at offset %d", ErrCorrupt, r.off)
+	}
+
+	var v jsonRecord
+	if err := json.Unmarshal(payload, &v); err != nil {
+		return Record{}, fmt.Errorf("%w: %w", ErrCorrupt, err)
+	}
+	rec := Record{Time: v.Time, State: v.State}
+	if v.State != nil {
+		v.State.SetFigureSeq(v.FigureSeq)
+	}
+	if v.Op != nil {
+		op, err := painter.UnmarshalOperation(v.Op)
+		if err != nil {
+			return Record{}, fmt.Errorf("%w: %w", ErrCorrupt, err)
+		}
+		rec.Op = op
+	}
+	if (rec.Op == nil) == (rec.State == nil) {
+		return Record{}, fmt.Errorf("%w: record at offset %d must have either op or state", ErrCorrupt, r.off)
+	}
+	r.off += headerSize + int64(size)
+	return rec, nil
+}
+
+// atEnd reports whether nothing follows the data read so far. After an
+// ErrCorrupt it tells whether the damaged record was the last one.
+func (r *Reader) atEnd() bool {
+	_, err := r.r.Peek(1)
+	return errors.Is(err, io.EOF)
+}
+
+// Offset returns the number of bytes taken by the records read so far.
+func (r *Reader) Offset() int64 {
+	return r.off
+}
@@ -0,0 +1,87 @@
+package lang
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/roman-mazur/architecture-lab-3/painter"
+)
+
+// ParseError describes a line of a script that could not be parsed or whose
+// operation failed. Line and Column start from 1, Column is 0 for a failed
+// operation and Line is 0 when the line is not known.
+type ParseError struct {
+	Line    int
+	Column  int
+	Command string
+	Err     error
+}
+
+func (e *ParseError) Error() string {
+	if e.Command == "" {
+		return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Err)
+	}
+	return fmt.Sprintf("line %d, column %d: %s: %s", e.Line, e.Column, e.Command, e.Err)
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+func (e *ParseError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Line    int    `json:"line,omitempty"`
+		Column  int    `json:"column,omitempty"`
+		Command string `json:"command,omitempty"`
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}{e.Line, e.Column, e.Command, errorCode(e.Err), e.Err.Error()})
+}
+
+// ParseErrors lists every error found in a script in line order.
+type ParseErrors []*ParseError
+
+func (es ParseErrors) Error() string {
+	msgs := make([]string, len(es))
+	for i, e := range es {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (es ParseErrors) Unwrap() []error {
+	res := make([]error, len(es))
+	for i, e := range es {
+		res[i] = e
+	}
+	return res
+}
+
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrUnknownCommand):
+		return "unknown_command"
+	case errors.Is(err, ErrInsufficientParams):
+		return "insufficient_params"
+	case errors.Is(err, ErrTooManyParams):
+		return "too_many_params"
+	case errors.Is(err, ErrUnknownOption):
+		return "unknown_option"
+	case errors.Is(err, painter.ErrBadColor):
+		return "invalid_color"
+	case errors.Is(err, ErrBadDepth):
+		return "invalid_depth"
+	case errors.Is(err, painter.ErrUnknownFigure):
+		return "unknown_figure"
+	case errors.Is(err, painter.ErrUnknownRect):
+		return "unknown_rect"
+	case errors.Is(err, painter.ErrBadCoordinates):
+		return "invalid_coordinates"
+	case errors.Is(err, strconv.ErrSyntax):
+		return "invalid_number"
+	case errors.Is(err, strconv.ErrRange):
+		return "number_out_of_range"
+	}
+	return "error"
+}
@@ -0,0 +1,91 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/roman-mazur/architecture-lab-3/ui/offscreen"
+	"golang.org/x/image/draw"
+)
+
+const maxFrameSide = 4096
+
+var ErrBadSize = errors.New("bad frame size")
+
+// FrameHandler serves the latest frame as PNG. The optional size query
+// parameter scales the frame to WxH, or to a square if only one side is given.
+func FrameHandler(frames *offscreen.FrameStore) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		img, n := frames.Latest()
+		if img == nil {
+			http.Error(rw, "no frame has been rendered yet", http.StatusNotFound)
+			return
+		}
+
+		// Кадр не змінився, тож не витрачаємо час на масштабування.
+		etag := fmt.Sprintf(`"%d"`, n)
+		rw.Header().Set("ETag", etag)
+		rw.Header().Set("X-Frame-Number", strconv.FormatUint(n, 10))
+		rw.Header().Set("Cache-Control", "no-cache")
+		if etagMatch(r.Header.Get("If-None-Match"), etag) {
+			rw.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		var out image.Image = img
+		if s := r.URL.Query().Get("size"); s != "" {
+			size, err := parseSize(s)
+			if err != nil {
+				http.Error(rw, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if size != img.Rect.Size() {
+				scaled := image.NewRGBA(image.Rectangle{Max: size})
+				draw.ApproxBiLinear.Scale(scaled, scaled.Rect, img, img.Rect, draw.Src, nil)
+				out = scaled
+			}
+		}
+
+		rw.Header().Set("Content-Type", "image/png")
+		if err := png.Encode(rw, out); err != nil {
+			log.Printf("Failed to encode frame: %s", err)
+		}
+	})
+}
+
+// etagMatch reports whether the If-None-Match header value lists etag or is
+// "*". Weak tags like W/"1" match too, as If-None-Match compares them weakly.
+func etagMatch(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func parseSize(s string) (image.Point, error) {
+	ws, hs, found := strings.Cut(s, "x")
+	if !found {
+		hs = ws
+	}
+	w, err := strconv.Atoi(ws)
+	if err != nil {
+		return image.Point{}, fmt.Errorf("%w: %s", ErrBadSize, s)
+	}
+	h, err := strconv.Atoi(hs)
+	if err != nil {
+		return image.Point{}, fmt.Errorf("%w: %s", ErrBadSize, s)
+	}
+	if w <= 0 || h <= 0 || w > maxFrameSide || h > maxFrameSide {
+		return image.Point{}, fmt.Errorf("%w: %s, each side must be in 1..%d", ErrBadSize, s, maxFrameSide)
+	}
+	return image.Pt(w, h), nil
+}
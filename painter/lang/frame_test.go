@@ -0,0 +1,79 @@
+package lang
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/roman-mazur/architecture-lab-3/ui/offscreen"
+)
+
+func TestFrameHandler(t *testing.T) {
+	var frames offscreen.FrameStore
+	h := FrameHandler(&frames)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/frame.png", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status before first frame: have: %d, want: %d", rec.Code, http.StatusNotFound)
+	}
+
+	tx := offscreen.NewTexture(image.Pt(100, 100))
+	tx.Fill(tx.Bounds(), color.White, draw.Src)
+	frames.Update(tx)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/frame.png?size=20x10", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status: have: %d, want: %d", rec.Code, http.StatusOK)
+	}
+	if n := rec.Header().Get("X-Frame-Number"); n != "1" {
+		t.Errorf("frame number: have: %q, want: %q", n, "1")
+	}
+	img, err := png.Decode(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := img.Bounds().Size(); size != image.Pt(20, 10) {
+		t.Errorf("scaled size: have: %v, want: %v", size, image.Pt(20, 10))
+	}
+
+	etag := rec.Header().Get("ETag")
+	for _, tc := range []struct {
+		header string
+		match  bool
+	}{
+		{etag, true},
+		{"W/" + etag, true},
+		{`"0", ` + etag, true},
+		{"*", true},
+		{`"0", W/"2"`, false},
+		{`"11"`, false},
+	} {
+		// Для незміненого кадру розмір навіть не розбирається, інакше
+		// неправильний розмір дає помилку.
+		req := httptest.NewRequest(http.MethodGet, "/frame.png?size=abc", nil)
+		req.Header.Set("If-None-Match", tc.header)
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		want := http.StatusBadRequest
+		if tc.match {
+			want = http.StatusNotModified
+		}
+		if rec.Code != want {
+			t.Errorf("status for If-None-Match %s: have: %d, want: %d", tc.header, rec.Code, want)
+		}
+	}
+
+	for _, size := range []string{"0", "abc", "10x", "5000"} {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/frame.png?size="+size, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status for size=%s: have: %d, want: %d", size, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package lang
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/roman-mazur/architecture-lab-3/painter"
+	"github.com/roman-mazur/architecture-lab-3/painter/journal"
+)
+
+// UndoHandler restores the state before the last change.
+func UndoHandler(loop *painter.Loop) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		apply(rw, r, loop, painter.Undo, nil)
+	})
+}
+
+// RedoHandler restores the state undone last.
+func RedoHandler(loop *painter.Loop) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		apply(rw, r, loop, painter.Redo, nil)
+	})
+}
+
+// HistoryHandler serves the number of steps that can be undone and redone.
+// With the at query parameter it renders the canvas from the journal as
+// TimeTravelHandler does.
+func HistoryHandler(loop *painter.Loop, j *journal.Journal) http.Handler {
+	travel := TimeTravelHandler(j)
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("at") {
+			travel.ServeHTTP(rw, r)
+			return
+		}
+
+		info, err := loop.History(r.Context())
+		switch {
+		case errors.Is(err, painter.ErrLoopStopped):
+			writeErrors(rw, http.StatusServiceUnavailable, err)
+			return
+		case err != nil:
+			writeErrors(rw, http.StatusInternalServerError, err)
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(rw).Encode(info); err != nil {
+			log.Printf("Failed to write history: %s", err)
+		}
+	})
+}
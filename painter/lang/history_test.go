@@ -0,0 +1,55 @@
+package lang
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/roman-mazur/architecture-lab-3/painter"
+	"github.com/roman-mazur/architecture-lab-3/ui/offscreen"
+)
+
+func TestHistoryHandlers(t *testing.T) {
+	var p Parser
+	l := painter.NewLoop()
+	go l.Start(offscreen.Screen{})
+	defer l.StopAndWait()
+
+	serve := func(h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s %s: status: have: %d, want: %d", method, target, rec.Code, http.StatusOK)
+		}
+		return rec
+	}
+	history := func() (info painter.HistoryInfo) {
+		rec := serve(HistoryHandler(l, nil), http.MethodGet, "/history", "")
+		if err := json.NewDecoder(rec.Body).Decode(&info); err != nil {
+			t.Fatal(err)
+		}
+		return info
+	}
+
+	serve(HttpHandler(l, &p), http.MethodPost, "/", "white\nupdate")
+	serve(HttpHandler(l, &p), http.MethodPost, "/", "figure 0.5 0.5\nupdate")
+	if info := history(); info.Undo != 2 || info.Redo != 0 {
+		t.Errorf("history: have: %+v, want 2 undo steps", info)
+	}
+
+	serve(UndoHandler(l), http.MethodPost, "/undo", "")
+	if info := history(); info.Undo != 1 || info.Redo != 1 {
+		t.Errorf("history after undo: have: %+v, want 1 undo and 1 redo step", info)
+	}
+	serve(RedoHandler(l), http.MethodPost, "/redo", "")
+	if info := history(); info.Undo != 2 || info.Redo != 0 {
+		t.Errorf("history after redo: have: %+v, want 2 undo steps", info)
+	}
+
+	serve(HttpHandler(l, &p), http.MethodPost, "/", "history 1")
+	if info := history(); info.Depth != 1 || info.Undo != 1 {
+		t.Errorf("history after depth change: have: %+v, want depth 1 with 1 undo step", info)
+	}
+}
@@ -1,6 +1,9 @@
 package lang
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
@@ -16,14 +19,69 @@ func HttpHandler(loop *painter.Loop, p *Parser) http.Handler {
 			in = strings.NewReader(r.URL.Query().Get("cmd"))
 		}
 
-		cmds, err := p.Parse(in)
+		priority := painter.PriorityInteractive
+		if s := r.URL.Query().Get("priority"); s != "" {
+			var err error
+			if priority, err = painter.ParsePriority(s); err != nil {
+				writeErrors(rw, http.StatusBadRequest, err)
+				return
+			}
+		}
+
+		cmds, lines, err := p.ParseLines(in)
 		if err != nil {
 			log.Printf("Bad script: %s", err)
-			rw.WriteHeader(http.StatusBadRequest)
+			writeErrors(rw, http.StatusBadRequest, err)
 			return
 		}
 
-		loop.Post(painter.OperationList(cmds))
-		rw.WriteHeader(http.StatusOK)
+		apply(rw, r, loop, painter.WithPriority(painter.OperationList(cmds), priority), lines)
 	})
 }
+
+// apply queues the operation without waiting for space in the queue and
+// reports the result of applying it. For an OperationList lines gives the
+// script line of every operation, it may be nil.
+func apply(rw http.ResponseWriter, r *http.Request, loop *painter.Loop, op painter.Operation, lines []int) {
+	var opErr *painter.OpError
+	switch err := loop.Apply(r.Context(), op); {
+	case errors.As(err, &opErr):
+		pe := &ParseError{Command: fmt.Sprint(opErr.Op), Err: opErr.Err}
+		if opErr.Index < len(lines) {
+			pe.Line = lines[opErr.Index]
+		}
+		writeErrors(rw, http.StatusUnprocessableEntity, ParseErrors{pe})
+	case errors.Is(err, painter.ErrQueueFull):
+		rw.Header().Set("Retry-After", "1")
+		writeErrors(rw, http.StatusTooManyRequests, err)
+	case errors.Is(err, painter.ErrLoopStopped):
+		writeErrors(rw, http.StatusServiceUnavailable, err)
+	case err != nil:
+		writeErrors(rw, http.StatusInternalServerError, err)
+	default:
+		rw.WriteHeader(http.StatusOK)
+	}
+}
+
+// writeErrors responds with a JSON document {"errors": [...]}. Parse errors
+// keep their position, other errors are reported by message only.
+func writeErrors(rw http.ResponseWriter, status int, err error) {
+	var (
+		res []any
+		pe  ParseErrors
+	)
+	switch {
+	case errors.As(err, &pe):
+		for _, e := range pe {
+			res = append(res, e)
+		}
+	default:
+		res = append(res, map[string]string{"message": err.Error()})
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	if err := json.NewEncoder(rw).Encode(map[string]any{"errors": res}); err != nil {
+		log.Printf("Failed to write error response: %s", err)
+	}
+}
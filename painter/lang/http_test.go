@@ -0,0 +1,145 @@
+package lang
+
+import (
+	"context"
+	"encoding/json"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/roman-mazur/architecture-lab-3/painter"
+	"github.com/roman-mazur/architecture-lab-3/ui/offscreen"
+)
+
+func TestHttpHandlerParseErrors(t *testing.T) {
+	var p Parser
+	h := HttpHandler(painter.NewLoop(), &p)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("white\nfigure 1 y\nfoo")))
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status: have: %d, want: %d", rec.Code, http.StatusBadRequest)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("content type: have: %q, want: %q", ct, "application/json")
+	}
+
+	var body struct {
+		Errors []struct {
+			Line    int    `json:"line"`
+			Column  int    `json:"column"`
+			Command string `json:"command"`
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Errors) != 2 {
+		t.Fatalf("len(errors): have: %d, want: 2", len(body.Errors))
+	}
+	if e := body.Errors[0]; e.Line != 2 || e.Column != 10 || e.Command != "figure" || e.Code != "invalid_number" {
+		t.Errorf("first error: have: %+v", e)
+	}
+	if e := body.Errors[1]; e.Line != 3 || e.Column != 1 || e.Code != "unknown_command" || e.Message == "" {
+		t.Errorf("second error: have: %+v", e)
+	}
+}
+
+func TestHttpHandlerQueueErrors(t *testing.T) {
+	var p Parser
+
+	full := painter.NewLoop()
+	for full.TryPost(painter.Update) == nil {
+	}
+	rec := httptest.NewRecorder()
+	HttpHandler(full, &p).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?cmd=white", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("status for full queue: have: %d, want: %d", rec.Code, http.StatusTooManyRequests)
+	}
+
+	stopped := painter.NewLoop()
+	go stopped.Start(offscreen.Screen{})
+	stopped.StopAndWait()
+	rec = httptest.NewRecorder()
+	HttpHandler(stopped, &p).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?cmd=white", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status for stopped loop: have: %d, want: %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestHttpHandlerPriority(t *testing.T) {
+	var p Parser
+	l := painter.NewLoop()
+	h := HttpHandler(l, &p)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?cmd=white&priority=urgent", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status for unknown priority: have: %d, want: %d", rec.Code, http.StatusBadRequest)
+	}
+
+	// Черга звичайних повідомлень заповнена, але керуюча лінія вільна.
+	for l.TryPost(painter.OperationList{}) == nil {
+	}
+	rec = httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/?priority=control", strings.NewReader("reset")))
+	}()
+	go l.Start(offscreen.Screen{})
+	<-done
+	l.StopAndWait()
+	if rec.Code != http.StatusOK {
+		t.Errorf("status for control priority: have: %d, want: %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestHttpHandlerOperationErrors(t *testing.T) {
+	var p Parser
+	l := painter.NewLoop()
+	go l.Start(offscreen.Screen{})
+	defer l.StopAndWait()
+	h := HttpHandler(l, &p)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("figure 0.5 0.5 id=a\nupdate")))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status: have: %d, want: %d", rec.Code, http.StatusOK)
+	}
+
+	rec = httptest.NewRecorder()
+	// Рядок рахується з коментарями та порожніми рядками.
+	script := "# header\n\n# more\ngreen\nmove a 0.1 0.1\nremove b\nupdate"
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(script)))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status: have: %d, want: %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+	var body struct {
+		Errors []struct {
+			Line    int    `json:"line"`
+			Command string `json:"command"`
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Errors) != 1 || body.Errors[0].Line != 6 || body.Errors[0].Command != "remove b" || body.Errors[0].Code != "unknown_figure" {
+		t.Errorf("errors: have: %+v", body.Errors)
+	}
+
+	snap, err := l.Snapshot(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := snap.Figures(); snap.Background() != color.Black || f[0].Pos != painter.Pt(0.5, 0.5) {
+		t.Errorf("failed script changed the state: background %v, figures %v", snap.Background(), f)
+	}
+}
@@ -4,97 +4,412 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
+	"image/color"
 	"io"
+	"slices"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/roman-mazur/architecture-lab-3/painter"
 )
 
 var (
-	ErrEmptyLine          = errors.New("empty line")
 	ErrUnknownCommand     = errors.New("unknown command")
 	ErrInsufficientParams = errors.New("insufficient number of parameters")
+	ErrTooManyParams      = errors.New("too many parameters")
+	ErrUnknownOption      = errors.New("unknown option")
+	ErrCommandExists      = errors.New("command already exists")
+	ErrBadDepth           = errors.New("history depth must be a non-negative integer")
 )
 
-type Parser struct{}
+// CommandFunc builds an operation for a custom command from its parameters.
+type CommandFunc func(params []string) (painter.Operation, error)
 
+type Parser struct {
+	commands map[string]CommandFunc
+}
+
+var builtins = []string{
+	"white", "green", "fill", "update", "bgrect", "bgrect-remove", "bgrect-clear",
+	"figure", "move", "move-all", "shift", "remove", "reset", "undo", "redo", "history",
+}
+
+// Register adds a custom command to the parser. It must not be called
+// concurrently with Parse.
+func (p *Parser) Register(name string, fn CommandFunc) error {
+	if slices.Contains(builtins, name) || p.commands[name] != nil {
+		return fmt.Errorf("%w: %s", ErrCommandExists, name)
+	}
+	if p.commands == nil {
+		p.commands = make(map[string]CommandFunc)
+	}
+	p.commands[name] = fn
+	return nil
+}
+
+// Parse reads the whole script and reports every line that fails to parse.
+// The returned error is ParseErrors in that case.
 func (p *Parser) Parse(in io.Reader) ([]painter.Operation, error) {
+	res, _, err := p.ParseLines(in)
+	return res, err
+}
+
+// ParseLines is like Parse and also returns the line of every operation in
+// the script, starting from 1. Empty lines and comments give no operations.
+func (p *Parser) ParseLines(in io.Reader) ([]painter.Operation, []int, error) {
 	scanner := bufio.NewScanner(in)
 	scanner.Split(bufio.ScanLines)
-	var res []painter.Operation
-	for scanner.Scan() {
+	var (
+		res   []painter.Operation
+		lines []int
+		errs  ParseErrors
+	)
+	for line := 1; scanner.Scan(); line++ {
 		commandLine := scanner.Text()
-		op, err := parse(commandLine)
+		if line == 1 {
+			commandLine = strings.TrimPrefix(commandLine, "\uFEFF")
+		}
+		op, err := p.parse(commandLine)
 		if err != nil {
-			return nil, err
+			err.Line = line
+			errs = append(errs, err)
+			continue
 		}
 
-		res = append(res, op)
+		if op != nil {
+			res = append(res, op)
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
 	}
-	return res, nil
+	if len(errs) != 0 {
+		return nil, nil, errs
+	}
+	return res, lines, nil
 }
 
-func parse(line string) (painter.Operation, error) {
-	parts := strings.Fields(line)
+func (p *Parser) parse(line string) (painter.Operation, *ParseError) {
+	parts := fields(line)
 	if len(parts) == 0 {
-		return nil, ErrEmptyLine
+		return nil, nil // Порожній рядок або коментар.
 	}
 
 	cmd, params := parts[0], parts[1:]
-	switch cmd {
+	if slices.Contains(builtins, cmd.text) {
+		params = trailingComment(cmd.text, params)
+	}
+	insufficient := func(want int) *ParseError {
+		return &ParseError{
+			Column:  cmd.col,
+			Command: cmd.text,
+			Err:     fmt.Errorf("%w: have: %d, want: %d", ErrInsufficientParams, len(params), want),
+		}
+	}
+	withCommand := func(err *ParseError) *ParseError {
+		err.Command = cmd.text
+		return err
+	}
+	tooMany := func(params []token, want int) *ParseError {
+		if len(params) <= want {
+			return nil
+		}
+		return &ParseError{
+			Column:  params[want].col,
+			Command: cmd.text,
+			Err:     fmt.Errorf("%w: have: %d, want: %d", ErrTooManyParams, len(params), want),
+		}
+	}
+
+	switch cmd.text {
 	case "white":
+		if err := tooMany(params, 0); err != nil {
+			return nil, err
+		}
 		return painter.WhiteFill, nil
 	case "green":
+		if err := tooMany(params, 0); err != nil {
+			return nil, err
+		}
 		return painter.GreenFill, nil
+	case "fill":
+		if len(params) < 1 {
+			return nil, insufficient(1)
+		}
+		if err := tooMany(params, 1); err != nil {
+			return nil, err
+		}
+		c, err := parseColor(params[0])
+		if err != nil {
+			return nil, withCommand(err)
+		}
+		return painter.Fill(c), nil
 	case "update":
+		if err := tooMany(params, 0); err != nil {
+			return nil, err
+		}
 		return painter.Update, nil
 	case "bgrect":
+		params, opts, err := options(params, "id")
+		if err != nil {
+			return nil, withCommand(err)
+		}
 		if len(params) < 4 {
-			return nil, fmt.Errorf("%w: have: %d, want: %d", ErrInsufficientParams, len(params), 4)
+			return nil, insufficient(4)
+		}
+		if err := tooMany(params, 5); err != nil {
+			return nil, err
 		}
 		p1, err := parsePoint(params[0], params[1])
 		if err != nil {
-			return nil, err
+			return nil, withCommand(err)
 		}
 		p2, err := parsePoint(params[2], params[3])
 		if err != nil {
+			return nil, withCommand(err)
+		}
+		var c color.Color = color.Black
+		if len(params) >= 5 {
+			if c, err = parseColor(params[4]); err != nil {
+				return nil, withCommand(err)
+			}
+		}
+		return painter.NamedBgRect(opts["id"].text, painter.Rectangle{Min: p1, Max: p2}, c), nil
+	case "bgrect-remove":
+		if len(params) < 1 {
+			return nil, insufficient(1)
+		}
+		if err := tooMany(params, 1); err != nil {
+			return nil, err
+		}
+		return painter.RemoveBgRect(params[0].text), nil
+	case "bgrect-clear":
+		if err := tooMany(params, 0); err != nil {
 			return nil, err
 		}
-		return painter.BgRect(painter.Rectangle{Min: p1, Max: p2}), nil
+		return painter.ClearBgRects, nil
 	case "figure":
+		params, opts, err := options(params, "id")
+		if err != nil {
+			return nil, withCommand(err)
+		}
 		if len(params) < 2 {
-			return nil, fmt.Errorf("%w: have: %d, want: %d", ErrInsufficientParams, len(params), 2)
+			return nil, insufficient(2)
 		}
-		p, err := parsePoint(params[0], params[1])
-		if err != nil {
+		if err := tooMany(params, 2); err != nil {
 			return nil, err
 		}
-		return painter.Figure(p), nil
+		pos, err := parsePoint(params[0], params[1])
+		if err != nil {
+			return nil, withCommand(err)
+		}
+		return painter.NamedFigure(opts["id"].text, pos), nil
 	case "move":
+		// Форма "move x y" без id залишена для сумісності та рухає всі фігури.
+		if len(params) == 2 {
+			pos, err := parsePoint(params[0], params[1])
+			if err != nil {
+				return nil, withCommand(err)
+			}
+			return painter.MoveAll(pos), nil
+		}
+		if len(params) < 3 {
+			return nil, insufficient(3)
+		}
+		if err := tooMany(params, 3); err != nil {
+			return nil, err
+		}
+		pos, err := parsePoint(params[1], params[2])
+		if err != nil {
+			return nil, withCommand(err)
+		}
+		return painter.Move(params[0].text, pos), nil
+	case "move-all":
 		if len(params) < 2 {
-			return nil, fmt.Errorf("%w: have: %d, want: %d", ErrInsufficientParams, len(params), 2)
+			return nil, insufficient(2)
+		}
+		if err := tooMany(params, 2); err != nil {
+			return nil, err
 		}
-		p, err := parsePoint(params[0], params[1])
+		pos, err := parsePoint(params[0], params[1])
 		if err != nil {
+			return nil, withCommand(err)
+		}
+		return painter.MoveAll(pos), nil
+	case "shift":
+		if len(params) < 3 {
+			return nil, insufficient(3)
+		}
+		if err := tooMany(params, 3); err != nil {
 			return nil, err
 		}
-		return painter.Move(p), nil
+		d, err := parsePoint(params[1], params[2])
+		if err != nil {
+			return nil, withCommand(err)
+		}
+		return painter.Shift(params[0].text, d), nil
+	case "remove":
+		if len(params) < 1 {
+			return nil, insufficient(1)
+		}
+		if err := tooMany(params, 1); err != nil {
+			return nil, err
+		}
+		return painter.RemoveFigure(params[0].text), nil
 	case "reset":
+		if err := tooMany(params, 0); err != nil {
+			return nil, err
+		}
 		return painter.Reset, nil
+	case "undo":
+		if err := tooMany(params, 0); err != nil {
+			return nil, err
+		}
+		return painter.Undo, nil
+	case "redo":
+		if err := tooMany(params, 0); err != nil {
+			return nil, err
+		}
+		return painter.Redo, nil
+	case "history":
+		if len(params) < 1 {
+			return nil, insufficient(1)
+		}
+		if err := tooMany(params, 1); err != nil {
+			return nil, err
+		}
+		depth, err := strconv.Atoi(params[0].text)
+		if err != nil || depth < 0 {
+			return nil, &ParseError{Column: params[0].col, Command: cmd.text, Err: fmt.Errorf("%w: %s", ErrBadDepth, params[0].text)}
+		}
+		return painter.SetHistoryDepth(depth), nil
 	default:
-		return nil, fmt.Errorf("%w: %s", ErrUnknownCommand, cmd)
+		fn, ok := p.commands[cmd.text]
+		if !ok {
+			return nil, &ParseError{
+				Column:  cmd.col,
+				Command: cmd.text,
+				Err:     fmt.Errorf("%w: %s", ErrUnknownCommand, cmd.text),
+			}
+		}
+		args := make([]string, len(params))
+		for i, param := range params {
+			args[i] = param.text
+		}
+		op, err := fn(args)
+		if err != nil {
+			return nil, &ParseError{Column: cmd.col, Command: cmd.text, Err: err}
+		}
+		return op, nil
+	}
+}
+
+// colorSlots are the positional parameters of builtin commands that take a
+// color.
+var colorSlots = map[string]int{"fill": 0, "bgrect": 4}
+
+// trailingComment drops a comment glued to '#', like "#note", from the
+// parameters of a builtin command. In a color slot such a token stays a
+// parameter and must be a valid color.
+func trailingComment(cmd string, params []token) []token {
+	slot, ok := colorSlots[cmd]
+	if !ok {
+		slot = -1
 	}
+	pos := 0
+	for i, p := range params {
+		if strings.HasPrefix(p.text, "#") && pos != slot {
+			return params[:i]
+		}
+		if !strings.Contains(p.text, "=") {
+			pos++ // Опції key=value не займають позиційних місць.
+		}
+	}
+	return params
 }
 
-func parsePoint(x, y string) (painter.Point, error) {
-	xf, err := strconv.ParseFloat(x, 32)
+func parsePoint(x, y token) (painter.Point, *ParseError) {
+	xf, err := strconv.ParseFloat(x.text, 32)
 	if err != nil {
-		return painter.Point{}, err
+		return painter.Point{}, &ParseError{Column: x.col, Err: err}
 	}
-	yf, err := strconv.ParseFloat(y, 32)
+	yf, err := strconv.ParseFloat(y.text, 32)
 	if err != nil {
-		return painter.Point{}, err
+		return painter.Point{}, &ParseError{Column: y.col, Err: err}
 	}
 	return painter.Pt(float32(xf), float32(yf)), nil
 }
+
+// options separates key=value parameters from positional ones.
+func options(params []token, allowed ...string) ([]token, map[string]token, *ParseError) {
+	var (
+		positional []token
+		opts       = make(map[string]token)
+	)
+	for _, p := range params {
+		key, value, found := strings.Cut(p.text, "=")
+		if !found {
+			positional = append(positional, p)
+			continue
+		}
+		if !slices.Contains(allowed, key) {
+			return nil, nil, &ParseError{Column: p.col, Err: fmt.Errorf("%w: %s", ErrUnknownOption, key)}
+		}
+		opts[key] = token{value, p.col + utf8.RuneCountInString(key) + 1}
+	}
+	return positional, opts, nil
+}
+
+func parseColor(t token) (color.Color, *ParseError) {
+	c, err := painter.ParseColor(t.text)
+	if err != nil {
+		return nil, &ParseError{Column: t.col, Err: err}
+	}
+	return c, nil
+}
+
+type token struct {
+	text string
+	col  int // Номер символу в рядку, починаючи з 1.
+}
+
+// fields splits the line by whitespace and drops comments. A comment starts
+// with '#' at the beginning of the line or with a standalone '#' token, so
+// colors like #ff0000 are still read as parameters. A '#' glued to a word
+// later in the line stays a parameter, see trailingComment.
+func fields(line string) []token {
+	var (
+		res   []token
+		start = -1
+		col   = 0
+		first = 0
+	)
+	for i, r := range line {
+		col++
+		if r == '#' && start < 0 && (len(res) == 0 || isCommentStart(line[i+1:])) {
+			break
+		}
+		if unicode.IsSpace(r) {
+			if start >= 0 {
+				res = append(res, token{line[start:i], first})
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start, first = i, col
+		}
+	}
+	if start >= 0 {
+		res = append(res, token{line[start:], first})
+	}
+	return res
+}
+
+func isCommentStart(rest string) bool {
+	r, _ := utf8.DecodeRuneInString(rest)
+	return rest == "" || r == '#' || unicode.IsSpace(r)
+}
@@ -2,6 +2,9 @@ package lang
 
 import (
 	"errors"
+	"image/color"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -37,16 +40,126 @@ func TestParse(t *testing.T) {
 					painter.Update,
 					painter.BgRect(painter.Rect(0.5, 0.75, 0.95, 1)),
 					painter.Figure(painter.Pt(0.01, 0.01)),
-					painter.Move(painter.Pt(0.69, 0.69)),
+					painter.MoveAll(painter.Pt(0.69, 0.69)),
 					painter.Reset,
 				},
 			},
 		},
 		{
-			name:  "empty line",
-			input: "white \n  \n green",
+			name:  "blank lines",
+			input: "\nwhite \n  \n green\n\n",
 			want: want{
-				err: ErrEmptyLine,
+				ops: []painter.Operation{
+					painter.WhiteFill,
+					painter.GreenFill,
+				},
+			},
+		},
+		{
+			name:  "comments",
+			input: "# header comment\n#compact comment\nwhite # trailing comment\n   # indented comment\nfigure 0.5 0.5 #\nupdate ## double",
+			want: want{
+				ops: []painter.Operation{
+					painter.WhiteFill,
+					painter.Figure(painter.Pt(0.5, 0.5)),
+					painter.Update,
+				},
+			},
+		},
+		{
+			// Коментар без пробілу, якщо на його місці не може бути кольору.
+			name:  "comments without a space",
+			input: "white #note\nfigure 0.5 0.5 #note\nfill #336699 #navy\nbgrect 0 0 1 1 id=a #ff0000 #red\nmove a 0.1 0.1 #todo\nupdate #done",
+			want: want{
+				ops: []painter.Operation{
+					painter.WhiteFill,
+					painter.Figure(painter.Pt(0.5, 0.5)),
+					painter.Fill(color.RGBA{R: 0x33, G: 0x66, B: 0x99, A: 0xff}),
+					painter.NamedBgRect("a", painter.Rect(0, 0, 1, 1), color.RGBA{R: 0xff, A: 0xff}),
+					painter.Move("a", painter.Pt(0.1, 0.1)),
+					painter.Update,
+				},
+			},
+		},
+		{
+			name:  "crlf",
+			input: "\uFEFFwhite\r\nbgrect 0.1 0.1 0.2 0.2\r\n\r\nupdate\r\n",
+			want: want{
+				ops: []painter.Operation{
+					painter.WhiteFill,
+					painter.BgRect(painter.Rect(0.1, 0.1, 0.2, 0.2)),
+					painter.Update,
+				},
+			},
+		},
+		{
+			name:  "colors",
+			input: "fill #336699\nfill navy\nbgrect 0 0 0.5 0.5 #ff000080\nbgrect 0.5 0.5 1 1 Gold",
+			want: want{
+				ops: []painter.Operation{
+					painter.Fill(color.RGBA{R: 0x33, G: 0x66, B: 0x99, A: 0xff}),
+					painter.Fill(color.RGBA{B: 0x80, A: 0xff}),
+					painter.BgRectColor(painter.Rect(0, 0, 0.5, 0.5), color.NRGBA{R: 0xff, A: 0x80}),
+					painter.BgRectColor(painter.Rect(0.5, 0.5, 1, 1), color.RGBA{R: 0xff, G: 0xd7, A: 0xff}),
+				},
+			},
+		},
+		{
+			name:  "rect layer",
+			input: "bgrect 0 0 0.5 0.5 id=header\nbgrect 0 0 1 1 red id=body\nbgrect-remove header\nbgrect-clear",
+			want: want{
+				ops: []painter.Operation{
+					painter.NamedBgRect("header", painter.Rect(0, 0, 0.5, 0.5), color.Black),
+					painter.NamedBgRect("body", painter.Rect(0, 0, 1, 1), color.RGBA{R: 0xff, A: 0xff}),
+					painter.RemoveBgRect("header"),
+					painter.ClearBgRects,
+				},
+			},
+		},
+		{
+			name:  "figures",
+			input: "figure 0.1 0.1 id=a\nmove a 0.2 0.3\nmove f1 0.5 0.5\nmove-all 0.4 0.4\nshift f1 -0.1 0.1\nremove a",
+			want: want{
+				ops: []painter.Operation{
+					painter.NamedFigure("a", painter.Pt(0.1, 0.1)),
+					painter.Move("a", painter.Pt(0.2, 0.3)),
+					painter.Move("f1", painter.Pt(0.5, 0.5)),
+					painter.MoveAll(painter.Pt(0.4, 0.4)),
+					painter.Shift("f1", painter.Pt(-0.1, 0.1)),
+					painter.RemoveFigure("a"),
+				},
+			},
+		},
+		{
+			name:  "history",
+			input: "undo\nredo\nhistory 20",
+			want: want{
+				ops: []painter.Operation{
+					painter.Undo,
+					painter.Redo,
+					painter.SetHistoryDepth(20),
+				},
+			},
+		},
+		{
+			name:  "bad history depth",
+			input: "history -1",
+			want: want{
+				err: ErrBadDepth,
+			},
+		},
+		{
+			name:  "unknown option",
+			input: "bgrect 0 0 1 1 name=x",
+			want: want{
+				err: ErrUnknownOption,
+			},
+		},
+		{
+			name:  "bad color",
+			input: "fill #12345",
+			want: want{
+				err: painter.ErrBadColor,
 			},
 		},
 		{
@@ -56,6 +169,21 @@ func TestParse(t *testing.T) {
 				err: ErrInsufficientParams,
 			},
 		},
+		{
+			name:  "too many parameters",
+			input: "update 1",
+			want: want{
+				err: ErrTooManyParams,
+			},
+		},
+		{
+			// На місці кольору коментар без пробілу читається як колір.
+			name:  "comment without a space instead of a color",
+			input: "bgrect 0 0 1 1 #note",
+			want: want{
+				err: painter.ErrBadColor,
+			},
+		},
 		{
 			name:  "bad command",
 			input: "badCommand",
@@ -81,22 +209,108 @@ func TestParse(t *testing.T) {
 			}
 			for i, op := range ops {
 				wantOp := tc.want.ops[i]
-				if !isOpsEqual(op, wantOp) {
-					t1 := painter.MockState()
-					r1 := op.Do(&t1)
-					t2 := painter.MockState()
-					r2 := wantOp.Do(&t2)
-					t.Fatalf("Parse(\n%s\n) failed on %d operation: ready and texture state after ops: (%t, %v), want: (%t, %v)", tc.input, i, r1, t1, r2, t2)
+				if !painter.EqualOps(op, wantOp) {
+					t.Fatalf("Parse(\n%s\n) failed on %d operation: have: %v, want: %v", tc.input, i, op, wantOp)
 				}
 			}
 		})
 	}
 }
 
-func isOpsEqual(op1, op2 painter.Operation) bool {
-	s1 := painter.MockState()
-	r1 := op1.Do(&s1)
-	s2 := painter.MockState()
-	r2 := op2.Do(&s2)
-	return r1 == r2 && s1.Equal(s2)
+func TestParseErrors(t *testing.T) {
+	var p Parser
+	input := "white\nbgrect 0.1 0.2 x 0.4\nfigure 0.5\n  draw 1 2\nupdate"
+
+	_, err := p.Parse(strings.NewReader(input))
+
+	var errs ParseErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Parse() err = %v, want ParseErrors", err)
+	}
+	want := []struct {
+		line, column int
+		command      string
+		err          error
+	}{
+		{2, 16, "bgrect", strconv.ErrSyntax},
+		{3, 1, "figure", ErrInsufficientParams},
+		{4, 3, "draw", ErrUnknownCommand},
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("len(errs): have: %d, want: %d (%v)", len(errs), len(want), err)
+	}
+	for i, w := range want {
+		e := errs[i]
+		if e.Line != w.line || e.Column != w.column || e.Command != w.command {
+			t.Errorf("error %d position: have: %d:%d %s, want: %d:%d %s", i, e.Line, e.Column, e.Command, w.line, w.column, w.command)
+		}
+		if !errors.Is(e, w.err) {
+			t.Errorf("error %d: have: %v, want: %v", i, e.Err, w.err)
+		}
+	}
+	if !errors.Is(err, ErrUnknownCommand) {
+		t.Errorf("ParseErrors does not unwrap to %v", ErrUnknownCommand)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	var p Parser
+	err := p.Register("corner", func(params []string) (painter.Operation, error) {
+		if len(params) < 1 {
+			return nil, ErrInsufficientParams
+		}
+		return painter.NamedFigure(params[0], painter.Pt(0, 0)), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Register("figure", nil); !errors.Is(err, ErrCommandExists) {
+		t.Errorf("Register(figure) err = %v, want: %v", err, ErrCommandExists)
+	}
+
+	ops, err := p.Parse(strings.NewReader("corner top\nupdate"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !painter.EqualOps(painter.OperationList(ops), painter.OperationList{painter.NamedFigure("top", painter.Pt(0, 0)), painter.Update}) {
+		t.Errorf("Parse() = %v", ops)
+	}
+
+	_, err = p.Parse(strings.NewReader("white\ncorner"))
+	var errs ParseErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Line != 2 || errs[0].Command != "corner" {
+		t.Errorf("custom command error: have: %v", err)
+	}
+}
+
+func TestParseLines(t *testing.T) {
+	var p Parser
+	ops, lines, err := p.ParseLines(strings.NewReader("# header\n\nwhite\n  # more\nfigure 0.5 0.5\nupdate\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{3, 5, 6}; len(ops) != len(want) || !slices.Equal(lines, want) {
+		t.Errorf("lines of %v: have: %v, want: %v", ops, lines, want)
+	}
+}
+
+func TestCommentWithoutSpace(t *testing.T) {
+	var p Parser
+	ops, err := p.Parse(strings.NewReader("white #note\nupdate #done\nbgrect 0 0 #note"))
+	var errs ParseErrors
+	if !errors.As(err, &errs) || len(errs) != 1 {
+		t.Fatalf("Parse() = %v, err = %v, want 1 error", ops, err)
+	}
+	// Коментар відкидається, тож прямокутнику бракує параметрів.
+	if e := errs[0]; e.Line != 3 || !errors.Is(e, ErrInsufficientParams) {
+		t.Errorf("error: have: %v, want line 3 with %v", e, ErrInsufficientParams)
+	}
+
+	// На місці кольору коментар без пробілу лишається помилкою.
+	for _, line := range []string{"fill #note", "bgrect 0 0 1 1 #note"} {
+		_, err := p.Parse(strings.NewReader(line))
+		if !errors.As(err, &errs) || len(errs) != 1 || !errors.Is(errs[0], painter.ErrBadColor) || errs[0].Column != strings.Index(line, "#")+1 {
+			t.Errorf("Parse(%q) err = %v, want %v at column %d", line, err, painter.ErrBadColor, strings.Index(line, "#")+1)
+		}
+	}
 }
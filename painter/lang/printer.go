@@ -0,0 +1,38 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/roman-mazur/architecture-lab-3/painter"
+)
+
+var ErrNotPrintable = errors.New("operation cannot be printed")
+
+// Print writes operations back as a script, one command per line, so that
+// Parse(Print(ops)) gives equal operations. Nested lists are flattened.
+func Print(ops []painter.Operation) (string, error) {
+	var sb strings.Builder
+	if err := printOps(&sb, ops); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+func printOps(sb *strings.Builder, ops []painter.Operation) error {
+	for _, op := range ops {
+		switch op := op.(type) {
+		case painter.OperationList:
+			if err := printOps(sb, op); err != nil {
+				return err
+			}
+		case fmt.Stringer:
+			sb.WriteString(op.String())
+			sb.WriteByte('\n')
+		default:
+			return fmt.Errorf("%w: %T", ErrNotPrintable, op)
+		}
+	}
+	return nil
+}
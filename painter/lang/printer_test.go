@@ -0,0 +1,65 @@
+package lang
+
+import (
+	"errors"
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/roman-mazur/architecture-lab-3/painter"
+)
+
+func TestPrintRoundTrip(t *testing.T) {
+	ops := []painter.Operation{
+		painter.WhiteFill,
+		painter.GreenFill,
+		painter.Fill(color.NRGBA{R: 0x12, G: 0x34, B: 0x56, A: 0x78}),
+		painter.BgRect(painter.Rect(0.1, 0.2, 0.3, 0.4)),
+		painter.NamedBgRect("panel", painter.Rect(0, 0, 1, 0.333), color.RGBA{R: 0xff, A: 0xff}),
+		painter.RemoveBgRect("panel"),
+		painter.ClearBgRects,
+		painter.OperationList{
+			painter.Figure(painter.Pt(0.01, 0.99)),
+			painter.NamedFigure("main", painter.Pt(0.5, 0.5)),
+		},
+		painter.Move("main", painter.Pt(0.7, 0.1)),
+		painter.Shift("main", painter.Pt(-0.05, 0.05)),
+		painter.MoveAll(painter.Pt(0.25, 0.75)),
+		painter.RemoveFigure("main"),
+		painter.Reset,
+		painter.Undo,
+		painter.Redo,
+		painter.SetHistoryDepth(5),
+		painter.Update,
+	}
+
+	text, err := Print(ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p Parser
+	parsed, err := p.Parse(strings.NewReader(text))
+	if err != nil {
+		t.Fatalf("Parse(Print(ops)) err = %v, script:\n%s", err, text)
+	}
+
+	var want painter.OperationList
+	for _, op := range ops {
+		if list, ok := op.(painter.OperationList); ok {
+			want = append(want, list...)
+		} else {
+			want = append(want, op)
+		}
+	}
+	if !want.Equal(painter.OperationList(parsed)) {
+		t.Errorf("Parse(Print(ops)) = %v, want: %v", parsed, want)
+	}
+}
+
+func TestPrintNotPrintable(t *testing.T) {
+	var custom painter.OperationFunc
+	_, err := Print([]painter.Operation{painter.Update, custom})
+	if !errors.Is(err, ErrNotPrintable) {
+		t.Errorf("Print() err = %v, want: %v", err, ErrNotPrintable)
+	}
+}
@@ -0,0 +1,103 @@
+package lang
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/roman-mazur/architecture-lab-3/painter"
+	"github.com/roman-mazur/architecture-lab-3/painter/record"
+	"github.com/roman-mazur/architecture-lab-3/ui/offscreen"
+)
+
+var (
+	ErrRecording    = errors.New("recording is already in progress")
+	ErrNotRecording = errors.New("no recording in progress")
+	ErrBadFormat    = errors.New("bad recording format: want gif or png")
+)
+
+// RecordHandlers start and stop recording the loop frames into a new file
+// in dir. The format query parameter of start selects an animated GIF
+// (the default) or a PNG sequence. The recording begins with the latest
+// frame from frames, if it is not nil. Both respond with the recording path.
+func RecordHandlers(loop *painter.Loop, frames *offscreen.FrameStore, dir string) (start, stop http.Handler) {
+	var (
+		mu  sync.Mutex
+		rec *record.Recorder
+	)
+
+	start = http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		name := "recording-" + time.Now().Format("20060102-150405.000")
+		switch f := r.URL.Query().Get("format"); f {
+		case "", "gif":
+			name += ".gif"
+		case "png":
+		default:
+			writeErrors(rw, http.StatusBadRequest, fmt.Errorf("%w: %s", ErrBadFormat, f))
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if rec != nil {
+			writeErrors(rw, http.StatusConflict, ErrRecording)
+			return
+		}
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			writeErrors(rw, http.StatusInternalServerError, err)
+			return
+		}
+		var err error
+		if rec, err = record.New(filepath.Join(dir, name)); err != nil {
+			writeErrors(rw, http.StatusInternalServerError, err)
+			return
+		}
+		if frames != nil {
+			if img, _ := frames.Latest(); img != nil {
+				rec.Add(img)
+			}
+		}
+		if err := loop.Subscribe(rec, painter.LatestWins); err != nil {
+			rec.Close()
+			rec = nil
+			writeErrors(rw, http.StatusServiceUnavailable, err)
+			return
+		}
+		writeRecording(rw, rec)
+	})
+
+	stop = http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if rec == nil {
+			writeErrors(rw, http.StatusConflict, ErrNotRecording)
+			return
+		}
+		loop.Unsubscribe(rec)
+		done := rec
+		rec = nil
+		if err := done.Close(); err != nil {
+			writeErrors(rw, http.StatusInternalServerError, err)
+			return
+		}
+		writeRecording(rw, done)
+	})
+	return start, stop
+}
+
+func writeRecording(rw http.ResponseWriter, rec *record.Recorder) {
+	rw.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(rw).Encode(map[string]any{
+		"path":   rec.Path(),
+		"frames": rec.Frames(),
+	})
+	if err != nil {
+		log.Printf("Failed to write recording info: %s", err)
+	}
+}
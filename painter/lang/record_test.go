@@ -0,0 +1,66 @@
+package lang
+
+import (
+	"encoding/json"
+	"image/gif"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/roman-mazur/architecture-lab-3/painter"
+	"github.com/roman-mazur/architecture-lab-3/ui/offscreen"
+)
+
+func TestRecordHandlers(t *testing.T) {
+	var frames offscreen.FrameStore
+	l := painter.NewLoop()
+	l.Receiver = &frames
+	go l.Start(offscreen.Screen{})
+	defer l.StopAndWait()
+
+	start, stop := RecordHandlers(l, &frames, t.TempDir())
+	serve := func(h http.Handler, target string, status int) (res struct {
+		Path   string `json:"path"`
+		Frames int    `json:"frames"`
+	}) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, target, nil))
+		if rec.Code != status {
+			t.Fatalf("%s: status: have: %d, want: %d", target, rec.Code, status)
+		}
+		json.NewDecoder(rec.Body).Decode(&res)
+		return
+	}
+
+	serve(stop, "/record/stop", http.StatusConflict)
+	serve(start, "/record/start?format=mp4", http.StatusBadRequest)
+
+	if err := l.Apply(t.Context(), painter.OperationList{painter.WhiteFill, painter.Update}); err != nil {
+		t.Fatal(err)
+	}
+	serve(start, "/record/start", http.StatusOK)
+	serve(start, "/record/start", http.StatusConflict)
+	if err := l.Apply(t.Context(), painter.OperationList{painter.GreenFill, painter.Update}); err != nil {
+		t.Fatal(err)
+	}
+	// Знімок гарантує, що кадр вже передано підписникам.
+	l.Snapshot(t.Context())
+	res := serve(stop, "/record/stop", http.StatusOK)
+
+	f, err := os.Open(res.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	anim, err := gif.DecodeAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anim.Image) != res.Frames || res.Frames < 1 {
+		t.Errorf("frames: have %d in the file and %d in the response", len(anim.Image), res.Frames)
+	}
+	if r, g, b, _ := anim.Image[0].At(1, 1).RGBA(); r != 0xffff || g != 0xffff || b != 0xffff {
+		t.Errorf("first frame is not the frame shown before start: %v", anim.Image[0].At(1, 1))
+	}
+}
@@ -0,0 +1,60 @@
+package lang
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/roman-mazur/architecture-lab-3/painter"
+)
+
+// StateHandler serves the current loop state as JSON.
+func StateHandler(loop *painter.Loop) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		snap, err := loop.Snapshot(r.Context())
+		switch {
+		case errors.Is(err, painter.ErrLoopStopped):
+			writeErrors(rw, http.StatusServiceUnavailable, err)
+			return
+		case err != nil:
+			writeErrors(rw, http.StatusInternalServerError, err)
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(rw).Encode(snap); err != nil {
+			log.Printf("Failed to write state: %s", err)
+		}
+	})
+}
+
+// SceneHandler serves the current loop state as an SVG image. The optional
+// size query parameter sets the image size as in FrameHandler.
+func SceneHandler(loop *painter.Loop) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		size := painter.FrameSize
+		if s := r.URL.Query().Get("size"); s != "" {
+			var err error
+			if size, err = parseSize(s); err != nil {
+				http.Error(rw, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		snap, err := loop.Snapshot(r.Context())
+		switch {
+		case errors.Is(err, painter.ErrLoopStopped):
+			writeErrors(rw, http.StatusServiceUnavailable, err)
+			return
+		case err != nil:
+			writeErrors(rw, http.StatusInternalServerError, err)
+			return
+		}
+
+		rw.Header().Set("Content-Type", "image/svg+xml")
+		if err := snap.State().WriteSVG(rw, size); err != nil {
+			log.Printf("Failed to write scene: %s", err)
+		}
+	})
+}
@@ -0,0 +1,64 @@
+package lang
+
+import (
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/roman-mazur/architecture-lab-3/painter"
+	"github.com/roman-mazur/architecture-lab-3/ui/offscreen"
+)
+
+func TestStateHandler(t *testing.T) {
+	l := painter.NewLoop()
+	go l.Start(offscreen.Screen{})
+	defer l.StopAndWait()
+
+	l.Post(painter.OperationList{
+		painter.WhiteFill,
+		painter.NamedBgRect("r", painter.Rect(0, 0, 0.5, 0.5), color.RGBA{G: 0xff, A: 0xff}),
+		painter.NamedFigure("f", painter.Pt(0.25, 0.75)),
+		painter.Figure(painter.Pt(0, 0)),
+		painter.RemoveFigure("f1"),
+	})
+
+	rec := httptest.NewRecorder()
+	StateHandler(l).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/state", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status: have: %d, want: %d", rec.Code, http.StatusOK)
+	}
+	want := `{"background":"#ffffff","rects":[{"id":"r","rect":{"min":{"x":0,"y":0},"max":{"x":0.5,"y":0.5}},"color":"#00ff00"}],"figures":[{"id":"f","pos":{"x":0.25,"y":0.75}}]}`
+	if body := strings.TrimSpace(rec.Body.String()); body != want {
+		t.Errorf("body:\nhave: %s\nwant: %s", body, want)
+	}
+}
+
+func TestSceneHandler(t *testing.T) {
+	l := painter.NewLoop()
+	go l.Start(offscreen.Screen{})
+	defer l.StopAndWait()
+	l.Post(painter.OperationList{painter.WhiteFill, painter.NamedFigure("main", painter.Pt(0.5, 0.5))})
+
+	rec := httptest.NewRecorder()
+	SceneHandler(l).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/scene.svg?size=60x30", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status: have: %d, want: %d", rec.Code, http.StatusOK)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "image/svg+xml" {
+		t.Errorf("content type: have: %q, want: %q", ct, "image/svg+xml")
+	}
+	body := rec.Body.String()
+	for _, want := range []string{`width="60" height="30"`, `fill="#ffffff"`, `<g id="figure-main">`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("scene does not contain %s:\n%s", want, body)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	SceneHandler(l).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/scene.svg?size=0", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status for bad size: have: %d, want: %d", rec.Code, http.StatusBadRequest)
+	}
+}
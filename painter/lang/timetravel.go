@@ -0,0 +1,100 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/roman-mazur/architecture-lab-3/painter"
+	"github.com/roman-mazur/architecture-lab-3/painter/journal"
+	"github.com/roman-mazur/architecture-lab-3/ui/offscreen"
+)
+
+var ErrBadTime = errors.New("bad time: want RFC 3339 or Unix seconds")
+
+// TimeTravelHandler renders the canvas as it was after a journal record and
+// serves it as PNG. The record is given by its number in the journal,
+// starting from 1, as the {n} path value, or as the last record made at or
+// before the at query parameter. Records before the last journal
+// compaction are not available. The optional size parameter works as in
+// FrameHandler.
+func TimeTravelHandler(j *journal.Journal) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if j == nil {
+			http.Error(rw, "journal is not enabled", http.StatusNotFound)
+			return
+		}
+
+		var (
+			keep func(n int, rec journal.Record) bool
+			want int // Номер запису, якщо його задано явно.
+		)
+		if s := r.PathValue("n"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 {
+				http.Error(rw, "record number must be a positive integer", http.StatusBadRequest)
+				return
+			}
+			want = n
+			keep = func(n int, rec journal.Record) bool { return n <= want }
+		} else {
+			at, err := parseTime(r.URL.Query().Get("at"))
+			if err != nil {
+				http.Error(rw, err.Error(), http.StatusBadRequest)
+				return
+			}
+			keep = func(n int, rec journal.Record) bool { return !rec.Time.After(at) }
+		}
+
+		size := painter.FrameSize
+		if s := r.URL.Query().Get("size"); s != "" {
+			var err error
+			if size, err = parseSize(s); err != nil {
+				http.Error(rw, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		var last journal.Record
+		state, n, err := j.Replay(func(n int, rec journal.Record) bool {
+			if keep(n, rec) {
+				last = rec
+				return true
+			}
+			return false
+		})
+		switch {
+		case err != nil:
+			log.Printf("Failed to replay journal: %s", err)
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		case n == 0 || n < want:
+			http.Error(rw, fmt.Sprintf("no such record, the journal has %d", n), http.StatusNotFound)
+			return
+		}
+
+		t := offscreen.NewTexture(size)
+		state.Render(t)
+		rw.Header().Set("Content-Type", "image/png")
+		rw.Header().Set("X-Journal-Record", strconv.Itoa(n))
+		rw.Header().Set("X-Record-Time", last.Time.Format(time.RFC3339Nano))
+		if err := png.Encode(rw, image.Image(t.RGBA())); err != nil {
+			log.Printf("Failed to encode frame: %s", err)
+		}
+	})
+}
+
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("%w: %q", ErrBadTime, s)
+}
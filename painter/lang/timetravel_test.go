@@ -0,0 +1,93 @@
+package lang
+
+import (
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/roman-mazur/architecture-lab-3/painter"
+	"github.com/roman-mazur/architecture-lab-3/painter/journal"
+)
+
+func TestTimeTravelHandler(t *testing.T) {
+	j, s, err := journal.Open(filepath.Join(t.TempDir(), "painter.journal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	commit := func(op painter.Operation) {
+		if _, err := op.Do(s); err != nil {
+			t.Fatal(err)
+		}
+		if err := j.Commit(op, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	commit(painter.WhiteFill)
+	time.Sleep(2 * time.Millisecond)
+	afterFirst := time.Now()
+	time.Sleep(2 * time.Millisecond)
+	commit(painter.OperationList{painter.GreenFill, painter.Update})
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /history", HistoryHandler(painter.NewLoop(), j))
+	mux.Handle("GET /history/{n}", TimeTravelHandler(j))
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	for _, tc := range []struct {
+		target string
+		want   color.Color
+	}{
+		{"/history/1", color.White},
+		{"/history/2?size=40", painter.GreenFill.Color},
+		{"/history?at=" + url.QueryEscape(afterFirst.Format(time.RFC3339Nano)), color.White},
+	} {
+		rec := get(tc.target)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: status: have: %d, want: %d", tc.target, rec.Code, http.StatusOK)
+			continue
+		}
+		img, err := png.Decode(rec.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r1, g1, b1, _ := img.At(1, 1).RGBA()
+		r2, g2, b2, _ := tc.want.RGBA()
+		if r1 != r2 || g1 != g2 || b1 != b2 {
+			t.Errorf("%s: background: have: %v, want: %v", tc.target, img.At(1, 1), tc.want)
+		}
+	}
+	if n := get("/history/1").Header().Get("X-Journal-Record"); n != "1" {
+		t.Errorf("record header: have: %q, want: %q", n, "1")
+	}
+
+	for _, tc := range []struct {
+		target string
+		status int
+	}{
+		{"/history/3", http.StatusNotFound},
+		{"/history/0", http.StatusBadRequest},
+		{"/history?at=0", http.StatusNotFound},
+		{"/history?at=yesterday", http.StatusBadRequest},
+	} {
+		if rec := get(tc.target); rec.Code != tc.status {
+			t.Errorf("%s: status: have: %d, want: %d", tc.target, rec.Code, tc.status)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	TimeTravelHandler(nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/history?at=0", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status without journal: have: %d, want: %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -1,84 +1,495 @@
 package painter
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"image"
+	"runtime/debug"
+	"sync"
+	"time"
 
 	"golang.org/x/exp/shiny/screen"
 )
 
+// Receiver gets rendered frames. See Loop.Subscribe for how long a texture
+// stays valid.
 type Receiver interface {
 	Update(t screen.Texture)
 }
 
+// TextureErrorPolicy defines what the loop does when it cannot allocate a
+// texture for a frame even after retries.
+type TextureErrorPolicy int
+
+const (
+	// SkipFrame drops the frame and keeps the loop running. The state is kept,
+	// so the next update renders it again.
+	SkipFrame TextureErrorPolicy = iota
+	// StopLoop stops the loop, and Start returns the error.
+	StopLoop
+)
+
+// PanicError describes a panic recovered while the loop handled Op. Op is
+// nil for a panic while a frame was rendered or delivered.
+type PanicError struct {
+	Op    Operation
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	if e.Op == nil {
+		return fmt.Sprintf("frame rendering panicked: %v", e.Value)
+	}
+	return fmt.Sprintf("operation %v panicked: %v", e.Op, e.Value)
+}
+
+var (
+	ErrTextureAlloc   = errors.New("texture allocation failed")
+	ErrUnknownMessage = errors.New("unknown message")
+	ErrQueueFull      = errors.New("message queue is full")
+	ErrLoopStopped    = errors.New("loop is stopped")
+)
+
 type Loop struct {
+	// Receiver, if set, is subscribed with the Blocking policy on Start.
 	Receiver Receiver
 
-	curr screen.Texture
+	// OnError is called from the loop goroutine for every error the loop
+	// recovers from. Failures of operations passed to Apply are returned to
+	// the caller instead, unless the operation panics. The last error is
+	// also available through Err.
+	OnError func(err error)
+
+	// TextureRetries is the number of extra attempts to allocate a texture.
+	TextureRetries int
+	OnTextureError TextureErrorPolicy
+
+	// FrameInterval enables frame coalescing: updates are rendered at most
+	// once per interval and intermediate frames are dropped. Zero renders
+	// every update.
+	FrameInterval time.Duration
+
+	// OnFrame is called from the loop goroutine after every delivered frame.
+	OnFrame func(stats FrameStats)
+
+	// InitialState, if set, is the state the loop starts from instead of an
+	// empty one. It is rendered as soon as the loop starts.
+	InitialState *State
 
-	state *textureState
+	// OnCommit is called from the loop goroutine after every operation that
+	// was applied without errors, with the state after it. The state must
+	// not be used after OnCommit returns. A returned error is reported.
+	OnCommit func(op Operation, s *State) error
+
+	// HistoryDepth is the number of states kept for Undo. A state is saved
+	// after every posted OperationList or operation that asks for an update.
+	// Zero disables the history.
+	HistoryDepth int
+
+	errMu sync.Mutex
+	err   error
+
+	pool texturePool
+
+	subsMu   sync.Mutex
+	subs     []*subscriber
+	subsDone bool // Цикл зупинився, нових отримувачів не додати.
+
+	frames    uint64
+	updates   int // Запити на оновлення з часу останнього кадру.
+	lastFrame time.Time
+	timer     *time.Timer
+
+	state   *State
+	history history
 
 	mq messageQueue
 
 	stop chan struct{}
 }
 
+type FrameStats struct {
+	Number uint64
+	// Coalesced is the number of updates dropped in favour of this frame.
+	Coalesced int
+}
+
 var (
-	size             = image.Pt(800, 800)
+	// FrameSize is the size of textures the loop renders to.
+	FrameSize        = image.Pt(800, 800)
 	MessageQueueSize = 1 << 10
 )
 
 func NewLoop() *Loop {
+	stop := make(chan struct{})
 	return &Loop{
-		mq:   messageQueue{make(chan any, MessageQueueSize)},
-		stop: make(chan struct{}),
+		mq:             newMessageQueue(MessageQueueSize, stop),
+		stop:           stop,
+		TextureRetries: 2,
+		HistoryDepth:   100,
 	}
 }
 
-func (l *Loop) Start(s screen.Screen) {
-	l.curr, _ = s.NewTexture(size)
+// Start processes messages until StopAndWait is called. It returns an error
+// only if the loop had to stop because of a failure.
+func (l *Loop) Start(s screen.Screen) (err error) {
+	l.pool.s, l.pool.size = s, FrameSize
+	if l.Receiver != nil {
+		if err := l.Subscribe(l.Receiver, Blocking); err != nil {
+			return err
+		}
+	}
 
-	l.state = newTextureState()
+	l.state = NewState()
+	if l.InitialState != nil {
+		l.state = l.InitialState.Clone()
+	}
+	l.history = history{depth: l.HistoryDepth, current: l.state.Clone()}
+
+	defer func() {
+		if l.timer != nil {
+			l.timer.Stop()
+			l.timer = nil
+		}
+		l.unsubscribeAll()
+		l.pool.release()
+		close(l.stop)
+	}()
+
+	if l.InitialState != nil {
+		// Відновлений стан показуємо одразу.
+		l.updates++
+		if err := l.protect(nil, l.flush); err != nil {
+			return err
+		}
+	}
 
-loop:
 	for {
-		switch msg := l.mq.pull().(type) {
+		var tick <-chan time.Time
+		if l.timer != nil {
+			tick = l.timer.C
+		}
+		switch msg := l.mq.pull(tick).(type) {
 		case Operation:
-			update := msg.Do(l.state)
-			if update {
-				l.state.set(l.curr)
-				l.Receiver.Update(l.curr)
-				l.curr, _ = s.NewTexture(size)
+			if _, err := l.apply(msg, true); err != nil {
+				return err
+			}
+
+		case applyRequest:
+			// Помилку отримує той, хто викликав Apply, тож її не повідомляємо.
+			failure, err := l.apply(msg.op, false)
+			msg.done <- failure
+			if err != nil {
+				return err
+			}
+
+		case query:
+			msg.fn()
+			close(msg.done)
+
+		case receiverPanic:
+			l.report(msg.err)
+
+		case frameTick:
+			l.timer = nil
+			if err := l.protect(nil, l.flush); err != nil {
+				return err
 			}
 
 		case closeSignal:
-			break loop
+			// Відкладений кадр показуємо перед зупинкою.
+			return l.protect(nil, l.flush)
 
 		default:
-			panic("message in messageQueue not Operation or closeSignal")
+			l.report(fmt.Errorf("%w: %T", ErrUnknownMessage, msg))
 		}
 	}
-	close(l.stop)
 }
 
-func (l *Loop) Post(op Operation) {
-	l.mq.push(op)
+// apply runs the operation and schedules a frame if it asks for one. The
+// operation failure is returned separately from errors that stop the loop.
+// It is reported only if report is set, panics are always reported.
+func (l *Loop) apply(op Operation, report bool) (failure, err error) {
+	var ready bool
+	failure = l.guard(op, func() (err error) {
+		if ready, err = l.do(op); err != nil {
+			return err
+		}
+		if _, isList := op.(OperationList); isList || ready {
+			l.history.commit(l.state)
+		}
+		return nil
+	})
+	if failure != nil {
+		var pe *PanicError
+		if report || errors.As(failure, &pe) {
+			l.report(failure)
+		}
+		return failure, nil
+	}
+
+	// Операцію вже застосовано, тож паніка далі не відкочує стан.
+	if l.OnCommit != nil {
+		err := l.protect(op, func() error { return l.OnCommit(op, l.state) })
+		if err != nil {
+			l.report(err)
+		}
+	}
+	if ready {
+		l.updates++
+	}
+	return nil, l.protect(nil, l.schedule)
 }
 
-type closeSignal struct{}
+// do applies the operation to the loop state. History operations are
+// handled here because they replace the whole state.
+func (l *Loop) do(op Operation) (ready bool, err error) {
+	switch op := op.(type) {
+	case OperationList:
+		for i, o := range op {
+			r, err := l.do(o)
+			if err != nil {
+				return false, &OpError{Index: i, Op: o, Err: err}
+			}
+			ready = r || ready
+		}
+		return ready, nil
+	case prioritized:
+		if o, ok := op.msg.(Operation); ok {
+			return l.do(o)
+		}
+		return false, nil
+	case UndoOp:
+		return l.restore(l.history.undo(l.state)), nil
+	case RedoOp:
+		return l.restore(l.history.redo(l.state)), nil
+	case HistoryDepthOp:
+		l.history.setDepth(op.Depth)
+		return false, nil
+	default:
+		return op.Do(l.state)
+	}
+}
 
-func (l *Loop) StopAndWait() {
-	l.mq.push(closeSignal{})
-	<-l.stop
+func (l *Loop) restore(s *State, ok bool) bool {
+	if ok {
+		// Undo не повертає лічильник ID, щоб ID не видавались повторно.
+		s.figureSeq = max(s.figureSeq, l.state.figureSeq)
+		l.state = s
+	}
+	return ok
 }
 
-type messageQueue struct {
-	buf chan any
+// guard runs fn, which changes the state for the operation. If fn fails or
+// panics, the state and the history are rolled back to their values before
+// the call and the failure is returned. A panic is returned as *PanicError.
+func (l *Loop) guard(op Operation, fn func() error) (failure error) {
+	before, hist := l.state.Clone(), l.history.clone()
+	defer func() {
+		if v := recover(); v != nil {
+			failure = &PanicError{Op: op, Value: v, Stack: debug.Stack()}
+		}
+		if failure != nil {
+			l.state, l.history = before, hist
+		}
+	}()
+	return fn()
 }
 
-func (mq *messageQueue) push(v any) {
-	mq.buf <- v
+// protect runs fn and reports a panic in it as *PanicError without changing
+// the state. It returns the error of fn.
+func (l *Loop) protect(op Operation, fn func() error) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			l.report(&PanicError{Op: op, Value: v, Stack: debug.Stack()})
+			err = nil
+		}
+	}()
+	return fn()
 }
 
-func (mq *messageQueue) pull() any {
-	return <-mq.buf
+// schedule renders pending updates now, or arms the timer if the last frame
+// was shown less than FrameInterval ago.
+func (l *Loop) schedule() error {
+	if l.updates == 0 {
+		return nil
+	}
+	wait := l.FrameInterval - time.Since(l.lastFrame)
+	if l.FrameInterval <= 0 || wait <= 0 {
+		return l.flush()
+	}
+	if l.timer == nil {
+		l.timer = time.NewTimer(wait)
+	}
+	return nil
+}
+
+func (l *Loop) flush() error {
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+	if l.updates == 0 {
+		return nil
+	}
+	coalesced := l.updates - 1
+	l.updates = 0
+	return l.render(coalesced)
+}
+
+// Err returns the last error the loop has met, or nil.
+func (l *Loop) Err() error {
+	l.errMu.Lock()
+	defer l.errMu.Unlock()
+	return l.err
+}
+
+func (l *Loop) report(err error) {
+	l.setErr(err)
+	if l.OnError != nil {
+		l.OnError(err)
+	}
+}
+
+// setErr keeps err for Err without calling OnError, so it can be called
+// from any goroutine.
+func (l *Loop) setErr(err error) {
+	l.errMu.Lock()
+	l.err = err
+	l.errMu.Unlock()
+}
+
+// render returns an error only if the loop must stop.
+func (l *Loop) render(coalesced int) error {
+	t, err := l.texture()
+	if err != nil {
+		err = fmt.Errorf("%w: %w", ErrTextureAlloc, err)
+		l.report(err)
+		if l.OnTextureError == StopLoop {
+			return err
+		}
+		return nil
+	}
+	defer l.pool.put(t)
+
+	l.state.Render(t)
+	for _, sub := range l.subscribers() {
+		sub.deliver(t)
+	}
+
+	l.frames++
+	l.lastFrame = time.Now()
+	if l.OnFrame != nil {
+		l.OnFrame(FrameStats{Number: l.frames, Coalesced: coalesced})
+	}
+	return nil
+}
+
+func (l *Loop) texture() (t screen.Texture, err error) {
+	for range l.TextureRetries + 1 {
+		if t, err = l.pool.get(); err == nil && t != nil {
+			return t, nil
+		}
+	}
+	if err == nil {
+		err = errors.New("screen returned nil texture")
+	}
+	return nil, err
+}
+
+// Post queues the operation, waiting while the queue is full. Operations
+// go to the PriorityInteractive lane unless wrapped with WithPriority.
+func (l *Loop) Post(op Operation) error {
+	return l.mq.push(context.Background(), op)
+}
+
+// TryPost queues the operation or returns ErrQueueFull without waiting.
+func (l *Loop) TryPost(op Operation) error {
+	return l.mq.tryPush(op)
+}
+
+// PostContext queues the operation, waiting while the queue is full until
+// the context is done.
+func (l *Loop) PostContext(ctx context.Context, op Operation) error {
+	return l.mq.push(ctx, op)
+}
+
+type applyRequest struct {
+	op   Operation
+	done chan error
+}
+
+// Apply queues the operation like TryPost and waits until the loop applies
+// it. If the operation fails, the state is left unchanged and the error is
+// returned, it is not passed to OnError. If ctx is done first, the operation may still be applied later.
+func (l *Loop) Apply(ctx context.Context, op Operation) error {
+	req := applyRequest{op, make(chan error, 1)}
+	var msg any = req
+	if p, ok := op.(prioritized); ok {
+		req.op = p.msg.(Operation)
+		msg = prioritized{req, p.priority}
+	}
+	if err := l.mq.tryPush(msg); err != nil {
+		return err
+	}
+	select {
+	case err := <-req.done:
+		return err
+	case <-l.stop:
+		select {
+		case err := <-req.done:
+			return err
+		default:
+			return ErrLoopStopped
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// query runs fn in the loop goroutine after the messages queued before it
+// in the same or a higher priority lane.
+type query struct {
+	fn   func()
+	done chan struct{}
+}
+
+func (l *Loop) query(ctx context.Context, fn func()) error {
+	q := query{fn, make(chan struct{})}
+	if err := l.mq.push(ctx, q); err != nil {
+		return err
+	}
+	select {
+	case <-q.done:
+		return nil
+	case <-l.stop:
+		// Запит міг виконатися перед зупинкою.
+		select {
+		case <-q.done:
+			return nil
+		default:
+			return ErrLoopStopped
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type closeSignal struct{}
+
+// StopAndWait stops the loop after every message queued before the call.
+// Messages queued later are dropped, so steady traffic in the higher lanes
+// does not delay the stop.
+func (l *Loop) StopAndWait() {
+	_ = l.mq.push(context.Background(), prioritized{drainMark{}, PriorityControl})
+	<-l.stop
+}
+
+// StopNow stops the loop as soon as the current message is handled and
+// drops everything still in the queue.
+func (l *Loop) StopNow() {
+	_ = l.mq.push(context.Background(), prioritized{closeSignal{}, PriorityControl})
+	<-l.stop
 }
@@ -1,20 +1,41 @@
 package painter
 
 import (
+	"context"
+	"errors"
 	"image"
 	"image/color"
 	"image/draw"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/roman-mazur/architecture-lab-3/ui"
+	"github.com/roman-mazur/architecture-lab-3/ui/offscreen"
 	"golang.org/x/exp/shiny/screen"
 )
 
+// mockReceiver keeps a copy of every frame, because the loop reuses
+// textures after they are displayed.
 type mockReceiver struct {
 	textures []screen.Texture
 }
 
 func (tr *mockReceiver) Update(t screen.Texture) {
-	tr.textures = append(tr.textures, t)
+	switch t := t.(type) {
+	case *mockTexture:
+		c := *t
+		c.rects = slices.Clone(t.rects)
+		tr.textures = append(tr.textures, &c)
+	case *offscreen.Texture:
+		c := offscreen.NewTexture(t.Size())
+		copy(c.RGBA().Pix, t.RGBA().Pix)
+		tr.textures = append(tr.textures, c)
+	default:
+		tr.textures = append(tr.textures, t)
+	}
 }
 
 type mockScreen struct{}
@@ -38,7 +59,7 @@ type mockTexture struct {
 
 func (m *mockTexture) Release() {}
 
-func (m *mockTexture) Size() image.Point { return size }
+func (m *mockTexture) Size() image.Point { return FrameSize }
 
 func (m *mockTexture) Bounds() image.Rectangle {
 	return image.Rectangle{Max: m.Size()}
@@ -49,33 +70,44 @@ func (m *mockTexture) Upload(dp image.Point, src screen.Buffer, sr image.Rectang
 func (m *mockTexture) Fill(dr image.Rectangle, src color.Color, op draw.Op) {
 	if dr == m.Bounds() {
 		m.bgColor = src
+		m.rects = nil
 	} else {
 		m.rects = append(m.rects, dr)
 	}
 }
 
-func TestBackgroundColoring(t *testing.T) {
+func TestRemoveBgRects(t *testing.T) {
 	var (
 		l = NewLoop()
 		r mockReceiver
+
+		rect1 = Rect(0.1, 0.1, 0.2, 0.2)
+		rect2 = Rect(0.2, 0.2, 0.3, 0.3)
 	)
 	l.Receiver = &r
 	go l.Start(mockScreen{})
 
-	l.Post(GreenFill)
-	l.Post(GreenFill)
-	l.Post(GreenFill)
-	l.Post(WhiteFill)
+	l.Post(NamedBgRect("a", rect1, color.Black))
+	l.Post(NamedBgRect("b", rect2, color.Black))
+	l.Post(RemoveBgRect("a"))
+	l.Post(RemoveBgRect("unknown"))
+	l.Post(Update)
+
+	l.Post(ClearBgRects)
 	l.Post(Update)
 	l.StopAndWait()
 
 	texture := r.textures[0].(*mockTexture)
-	if !isColorsEqual(texture.bgColor, color.White) {
-		t.Errorf("background is not white")
+	if want := []image.Rectangle{rect2.Resize(FrameSize).ToImage()}; !slices.Equal(texture.rects, want) {
+		t.Errorf("after remove: have: %v, want: %v", texture.rects, want)
+	}
+	texture = r.textures[1].(*mockTexture)
+	if len(texture.rects) != 0 {
+		t.Errorf("after clear: have: %v, want no rects", texture.rects)
 	}
 }
 
-func TestLastBgRect(t *testing.T) {
+func TestManyUpdates(t *testing.T) {
 	var (
 		l = NewLoop()
 		r mockReceiver
@@ -83,31 +115,38 @@ func TestLastBgRect(t *testing.T) {
 		rect1 = Rect(1.1, 1.2, 1.3, 1.4)
 		rect2 = Rect(2.1, 2.2, 2.3, 2.4)
 		rect3 = Rect(3.1, 3.2, 3.3, 3.4)
-		rect4 = Rect(4.1, 4.2, 4.3, 4.4)
 	)
 	l.Receiver = &r
 	go l.Start(mockScreen{})
 
 	l.Post(BgRect(rect1))
+	l.Post(Update)
+
 	l.Post(BgRect(rect2))
-	l.Post(BgRect(rect3))
-	l.Post(BgRect(rect4))
+	l.Post(Update)
 
+	l.Post(BgRect(rect3))
 	l.Post(Update)
 	l.StopAndWait()
 
-	want := rect4.Resize(size).ToImage()
+	if len(r.textures) != 3 {
+		t.Errorf("must be 3 separate textures, have: %d", len(r.textures))
+	}
 
-	texture := r.textures[0].(*mockTexture)
-	if len(texture.rects) != 1 {
-		t.Errorf("saved more than last BgRect")
+	rects := []image.Rectangle{
+		rect1.Resize(FrameSize).ToImage(),
+		rect2.Resize(FrameSize).ToImage(),
+		rect3.Resize(FrameSize).ToImage(),
 	}
-	if texture.rects[0] != want {
-		t.Errorf("have: %v, want: %v", texture.rects[0], want)
+	for i, tx := range r.textures {
+		texture := tx.(*mockTexture)
+		if want := rects[:i+1]; !slices.Equal(texture.rects, want) {
+			t.Errorf("texture %d shapes: have: %v, want: %v", i, texture.rects, want)
+		}
 	}
 }
 
-func TestFigure(t *testing.T) {
+func TestStopAndWaint(t *testing.T) {
 	var (
 		l = NewLoop()
 		r mockReceiver
@@ -115,47 +154,82 @@ func TestFigure(t *testing.T) {
 	l.Receiver = &r
 	go l.Start(mockScreen{})
 
-	l.Post(Figure(Point{}))
+	l.StopAndWait()
 
-	l.Post(Update)
+	if err := l.Post(GreenFill); !errors.Is(err, ErrLoopStopped) {
+		t.Errorf("Post() after stop = %v, want: %v", err, ErrLoopStopped)
+	}
+	if err := l.TryPost(Update); !errors.Is(err, ErrLoopStopped) {
+		t.Errorf("TryPost() after stop = %v, want: %v", err, ErrLoopStopped)
+	}
+	if err := l.PostContext(context.Background(), Update); !errors.Is(err, ErrLoopStopped) {
+		t.Errorf("PostContext() after stop = %v, want: %v", err, ErrLoopStopped)
+	}
 	l.StopAndWait()
 
-	texture := r.textures[0].(*mockTexture)
-	if len(texture.rects) == 0 {
-		t.Errorf("figure does not create any shapes")
+	if len(r.textures) != 0 {
+		t.Errorf("update has an effect after closing")
 	}
 }
 
-func TestRectAndFigure(t *testing.T) {
+func TestFullQueue(t *testing.T) {
 	var (
 		l = NewLoop()
 		r mockReceiver
+	)
+	l.Receiver = &r
+
+	// Цикл ще не запущено, тож черга лише заповнюється.
+	for range MessageQueueSize {
+		if err := l.TryPost(WhiteFill); err != nil {
+			t.Fatalf("TryPost() = %v before the queue is full", err)
+		}
+	}
+	if err := l.TryPost(Update); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("TryPost() = %v, want: %v", err, ErrQueueFull)
+	}
 
-		rect = Rect(1, 2, 3, 4)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.PostContext(ctx, Update); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("PostContext() = %v, want: %v", err, context.DeadlineExceeded)
+	}
+
+	go l.Start(mockScreen{})
+	if err := l.PostContext(context.Background(), Update); err != nil {
+		t.Errorf("PostContext() = %v after the loop started", err)
+	}
+	l.StopAndWait()
+
+	if len(r.textures) != 1 {
+		t.Errorf("have %d frames, want 1", len(r.textures))
+	}
+}
+
+func TestMove(t *testing.T) {
+	var (
+		l = NewLoop()
+		r mockReceiver
+
+		rect = Rect(1, 1, 1, 1)
+		move = Pt(2, 2)
 	)
 	l.Receiver = &r
 	go l.Start(mockScreen{})
 
 	l.Post(BgRect(rect))
-	l.Post(Figure(Point{0.5, 0.5}))
-	l.Post(GreenFill)
-
+	l.Post(MoveAll(move))
 	l.Post(Update)
+
 	l.StopAndWait()
 
 	texture := r.textures[0].(*mockTexture)
-	if len(texture.rects) < 2 {
-		t.Errorf("no square or shape was created")
-	}
-	if texture.rects[0] != rect.Resize(size).ToImage() {
-		t.Errorf("wrong rect: have: %v, want: %v", texture.rects[0], rect.Resize(size).ToImage())
-	}
-	if !isColorsEqual(texture.bgColor, color.RGBA{G: 0xff, A: 0xff}) {
-		t.Errorf("background color did not change to green")
+	if texture.rects[0] != rect.Resize(FrameSize).ToImage() {
+		t.Errorf("move has an effect on BgRect")
 	}
 }
 
-func TestReset(t *testing.T) {
+func TestFigureIDs(t *testing.T) {
 	var (
 		l = NewLoop()
 		r mockReceiver
@@ -163,111 +237,771 @@ func TestReset(t *testing.T) {
 	l.Receiver = &r
 	go l.Start(mockScreen{})
 
-	l.Post(Figure(Point{}))
-	l.Post(BgRect(Rectangle{}))
-	l.Post(WhiteFill)
-	l.Post(Reset)
+	l.Post(Figure(Pt(0.1, 0.1)))
+	l.Post(NamedFigure("main", Pt(0.2, 0.2)))
+	l.Post(Figure(Pt(0.3, 0.3)))
+	l.Post(Update)
 
+	l.Post(Move("main", Pt(0.5, 0.5)))
+	l.Post(Shift("f1", Pt(0.1, -0.1)))
+	l.Post(RemoveFigure("f2"))
+	l.Post(Update)
+
+	l.Post(MoveAll(Pt(0.9, 0.9)))
 	l.Post(Update)
 	l.StopAndWait()
 
-	texture := r.textures[0].(*mockTexture)
-	if len(texture.rects) != 0 {
-		t.Errorf("texture contain shapes")
+	figures := func(i int) []image.Rectangle {
+		rects := r.textures[i].(*mockTexture).rects
+		// Кожна фігура складається з двох прямокутників, беремо горизонтальний.
+		var res []image.Rectangle
+		for j := 0; j < len(rects); j += 2 {
+			res = append(res, rects[j])
+		}
+		return res
+	}
+	bar := func(x, y float32) image.Rectangle {
+		var tx mockTexture
+		ui.Figure(&tx, Pt(x, y).Resize(FrameSize).ToImage())
+		return tx.rects[0]
+	}
+
+	if have := figures(0); len(have) != 3 {
+		t.Fatalf("first frame: have %d figures, want 3", len(have))
+	}
+	if have, want := figures(1), []image.Rectangle{bar(0.2, 0), bar(0.5, 0.5)}; !slices.Equal(have, want) {
+		t.Errorf("second frame: have: %v, want: %v", have, want)
 	}
-	if !isColorsEqual(texture.bgColor, color.Black) {
-		t.Errorf("texture is not black")
+	if have, want := figures(2), []image.Rectangle{bar(0.9, 0.9), bar(0.9, 0.9)}; !slices.Equal(have, want) {
+		t.Errorf("third frame: have: %v, want: %v", have, want)
 	}
 }
 
-func TestManyUpdates(t *testing.T) {
+// countingScreen tracks how many textures are allocated and not released.
+type countingScreen struct {
+	mockScreen
+	live, max atomic.Int64
+}
+
+func (s *countingScreen) NewTexture(size image.Point) (screen.Texture, error) {
+	t, err := s.mockScreen.NewTexture(size)
+	if n := s.live.Add(1); n > s.max.Load() {
+		s.max.Store(n)
+	}
+	return &countingTexture{t, s}, err
+}
+
+type countingTexture struct {
+	screen.Texture
+	s *countingScreen
+}
+
+func (t *countingTexture) Release() {
+	t.s.live.Add(-1)
+	t.Texture.Release()
+}
+
+type discardReceiver struct{}
+
+func (discardReceiver) Update(t screen.Texture) {}
+
+func TestTexturePool(t *testing.T) {
 	var (
 		l = NewLoop()
-		r mockReceiver
+		s countingScreen
+	)
+	l.Receiver = discardReceiver{}
+	go l.Start(&s)
 
-		rect1 = Rect(1.1, 1.2, 1.3, 1.4)
-		rect2 = Rect(2.1, 2.2, 2.3, 2.4)
-		rect3 = Rect(3.1, 3.2, 3.3, 3.4)
+	for i := range 5000 {
+		l.Post(NamedFigure("a", Pt(float32(i%100)/100, 0.5)))
+		l.Post(Update)
+	}
+	l.StopAndWait()
+
+	if m := s.max.Load(); m > 2 {
+		t.Errorf("live textures must stay bounded by double buffering, have max: %d", m)
+	}
+	if n := s.live.Load(); n != 0 {
+		t.Errorf("textures are not released after stop, live: %d", n)
+	}
+}
+
+// failingScreen fails the first n texture allocations.
+type failingScreen struct {
+	mockScreen
+	n int
+}
+
+func (s *failingScreen) NewTexture(size image.Point) (screen.Texture, error) {
+	if s.n > 0 {
+		s.n--
+		return nil, errors.New("out of memory")
+	}
+	return s.mockScreen.NewTexture(size)
+}
+
+func TestTextureErrorSkipsFrame(t *testing.T) {
+	var (
+		l      = NewLoop()
+		r      mockReceiver
+		errs   []error
+		result = make(chan error, 1)
 	)
 	l.Receiver = &r
-	go l.Start(mockScreen{})
+	l.OnError = func(err error) { errs = append(errs, err) }
+	go func() { result <- l.Start(&failingScreen{n: 3}) }()
 
-	l.Post(BgRect(rect1))
 	l.Post(Update)
+	l.Post(WhiteFill)
+	l.Post(Update)
+	l.Post(nil)
+	l.StopAndWait()
+
+	if err := <-result; err != nil {
+		t.Errorf("Start() = %v, want nil", err)
+	}
+	if len(r.textures) != 1 {
+		t.Fatalf("must skip only the first frame, have %d frames", len(r.textures))
+	}
+	if !isColorsEqual(r.textures[0].(*mockTexture).bgColor, color.White) {
+		t.Errorf("state was lost with the skipped frame")
+	}
+	if len(errs) != 2 || !errors.Is(errs[0], ErrTextureAlloc) || !errors.Is(errs[1], ErrUnknownMessage) {
+		t.Errorf("reported errors: have: %v", errs)
+	}
+	if !errors.Is(l.Err(), ErrUnknownMessage) {
+		t.Errorf("Err() = %v, want: %v", l.Err(), ErrUnknownMessage)
+	}
+}
+
+func TestTextureErrorStopsLoop(t *testing.T) {
+	var (
+		l      = NewLoop()
+		r      mockReceiver
+		result = make(chan error, 1)
+	)
+	l.Receiver = &r
+	l.OnTextureError = StopLoop
+	go func() { result <- l.Start(&failingScreen{n: 3}) }()
 
-	l.Post(BgRect(rect2))
 	l.Post(Update)
+	if err := <-result; !errors.Is(err, ErrTextureAlloc) {
+		t.Errorf("Start() = %v, want: %v", err, ErrTextureAlloc)
+	}
+	l.StopAndWait()
+}
+
+func TestTextureRetry(t *testing.T) {
+	var (
+		l = NewLoop()
+		r mockReceiver
+	)
+	l.Receiver = &r
+	go l.Start(&failingScreen{n: 2})
 
-	l.Post(BgRect(rect3))
 	l.Post(Update)
 	l.StopAndWait()
 
-	if len(r.textures) != 3 {
-		t.Errorf("must be 3 separate textures, have: %d", len(r.textures))
+	if len(r.textures) != 1 || l.Err() != nil {
+		t.Errorf("allocation must succeed on retry, have %d frames, err: %v", len(r.textures), l.Err())
 	}
+}
+
+func TestPanicIsolation(t *testing.T) {
+	var (
+		l    = NewLoop()
+		r    mockReceiver
+		errs []error
+	)
+	l.Receiver = &r
+	l.OnError = func(err error) { errs = append(errs, err) }
+	go l.Start(mockScreen{})
+
+	l.Post(WhiteFill)
+	l.Post(OperationList{
+		GreenFill,
+		Figure(Pt(0.5, 0.5)),
+		OperationFunc(func(s *State) error { panic("broken operation") }),
+	})
+	l.Post(Update)
+	l.StopAndWait()
 
+	if len(r.textures) != 1 {
+		t.Fatalf("loop did not survive the panic, have %d frames", len(r.textures))
+	}
 	texture := r.textures[0].(*mockTexture)
-	if len(texture.rects) != 1 {
-		t.Errorf("first texture has more than one shape, have: %d", len(texture.rects))
+	if !isColorsEqual(texture.bgColor, color.White) || len(texture.rects) != 0 {
+		t.Errorf("state was not rolled back: background %v, shapes %v", texture.bgColor, texture.rects)
 	}
-	if texture.rects[0] != rect1.Resize(size).ToImage() {
-		t.Errorf("first texture does not have wanted shape, have: %v, want: %v", texture.rects[0], rect1.Resize(size).ToImage())
+
+	var pe *PanicError
+	if len(errs) != 1 || !errors.As(errs[0], &pe) {
+		t.Fatalf("reported errors: have: %v, want one *PanicError", errs)
 	}
+	if pe.Value != "broken operation" || len(pe.Stack) == 0 {
+		t.Errorf("panic error: value %v, stack size %d", pe.Value, len(pe.Stack))
+	}
+	if _, ok := pe.Op.(OperationList); !ok {
+		t.Errorf("failing operation: have: %T, want: OperationList", pe.Op)
+	}
+}
 
-	texture = r.textures[1].(*mockTexture)
-	if len(texture.rects) != 1 {
-		t.Errorf("second texture has more than one shape, have: %d", len(texture.rects))
+// panicReceiver panics on the first frame.
+type panicReceiver struct{ frames int }
+
+func (r *panicReceiver) Update(t screen.Texture) {
+	r.frames++
+	if r.frames == 1 {
+		panic("broken receiver")
 	}
-	if texture.rects[0] != rect2.Resize(size).ToImage() {
-		t.Errorf("second texture does not have wanted shape, have: %v, want: %v", texture.rects[0], rect2.Resize(size).ToImage())
+}
+
+func TestRenderPanicKeepsState(t *testing.T) {
+	var (
+		l       = NewLoop()
+		r       panicReceiver
+		errs    []error
+		commits int
+	)
+	l.Receiver = &r
+	l.OnError = func(err error) { errs = append(errs, err) }
+	l.OnCommit = func(op Operation, s *State) error {
+		commits++
+		return nil
+	}
+	go l.Start(mockScreen{})
+
+	l.Post(OperationList{GreenFill, Update})
+	l.Post(Update)
+	snap, err := l.Snapshot(context.Background())
+	l.StopAndWait()
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	texture = r.textures[2].(*mockTexture)
-	if len(texture.rects) != 1 {
-		t.Errorf("third texture has more than one shape, have: %d", len(texture.rects))
+	// Операція вдалася, тож паніка під час показу кадру не відкочує її.
+	if !isColorsEqual(snap.Background(), GreenFill.Color) || commits != 2 {
+		t.Errorf("state after a render panic: background %v, %d commits", snap.Background(), commits)
 	}
-	if texture.rects[0] != rect3.Resize(size).ToImage() {
-		t.Errorf("third texture does not have wanted shape, have: %v, want: %v", texture.rects[0], rect3.Resize(size).ToImage())
+	if r.frames != 2 {
+		t.Errorf("frames after the panic: have: %d, want: 2", r.frames)
+	}
+	var pe *PanicError
+	if len(errs) != 1 || !errors.As(errs[0], &pe) || pe.Op != nil {
+		t.Errorf("reported errors: have: %v, want one *PanicError without an operation", errs)
 	}
 }
 
-func TestStopAndWaint(t *testing.T) {
+// recordOp appends its name to the shared log when the loop applies it.
+type recordOp struct {
+	name string
+	log  *[]string
+}
+
+func (op recordOp) Do(s *State) (bool, error) {
+	*op.log = append(*op.log, op.name)
+	return false, nil
+}
+
+func TestPriorityLanes(t *testing.T) {
 	var (
-		l = NewLoop()
-		r mockReceiver
+		l   = NewLoop()
+		r   mockReceiver
+		log []string
+	)
+	l.Receiver = &r
+	post := func(name string, p Priority) {
+		if err := l.Post(WithPriority(recordOp{name, &log}, p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Повідомлення накопичуються до запуску циклу.
+	post("bulk-1", PriorityBulk)
+	post("interactive-1", PriorityInteractive)
+	post("bulk-2", PriorityBulk)
+	post("control-1", PriorityControl)
+	l.Post(recordOp{"interactive-2", &log})
+	post("control-2", PriorityControl)
+
+	go l.Start(mockScreen{})
+	l.StopAndWait()
+
+	want := []string{"control-1", "control-2", "interactive-1", "interactive-2", "bulk-1", "bulk-2"}
+	if !slices.Equal(log, want) {
+		t.Errorf("order: have: %v, want: %v", log, want)
+	}
+}
+
+func TestStopNow(t *testing.T) {
+	var (
+		l   = NewLoop()
+		r   mockReceiver
+		log []string
 	)
 	l.Receiver = &r
+
+	l.Post(recordOp{"interactive", &log})
+	l.Post(WithPriority(recordOp{"control", &log}, PriorityControl))
+	go l.StopNow()
+
+	// StopNow має випередити звичайні повідомлення, але не керуючі.
+	for len(l.mq.lanes[PriorityControl]) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	go l.Start(mockScreen{})
+	<-l.stop
+
+	if want := []string{"control"}; !slices.Equal(log, want) {
+		t.Errorf("applied before stop: have: %v, want: %v", log, want)
+	}
+}
+
+func TestStopAndWaitUnderLoad(t *testing.T) {
+	var (
+		l     = NewLoop()
+		log   []string
+		again OperationFunc
+	)
+	// Операція знову ставить себе в чергу, тож інтерактивна смуга ніколи
+	// не порожніє. Це не має відкладати зупинку.
+	again = func(s *State) error {
+		_ = l.TryPost(again)
+		return nil
+	}
+	l.Post(again)
+	l.Post(WithPriority(recordOp{"bulk", &log}, PriorityBulk))
 	go l.Start(mockScreen{})
 
+	stopped := make(chan struct{})
+	go func() {
+		l.StopAndWait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("StopAndWait did not return under interactive load")
+	}
+	if want := []string{"bulk"}; !slices.Equal(log, want) {
+		t.Errorf("applied before stop: have: %v, want: %v", log, want)
+	}
+}
+
+func TestFrameCoalescing(t *testing.T) {
+	var (
+		l     = NewLoop()
+		r     mockReceiver
+		stats []FrameStats
+	)
+	l.Receiver = &r
+	l.FrameInterval = time.Hour
+	l.OnFrame = func(s FrameStats) { stats = append(stats, s) }
+
+	for i := range 10 {
+		l.Post(NamedFigure("a", Pt(float32(i)/10, 0.5)))
+		l.Post(Update)
+	}
+	go l.Start(mockScreen{})
 	l.StopAndWait()
 
-	l.Post(GreenFill)
+	// Перший кадр показується одразу, решта чекає інтервалу і зливається в один.
+	want := []FrameStats{{Number: 1}, {Number: 2, Coalesced: 8}}
+	if !slices.Equal(stats, want) {
+		t.Errorf("frame stats: have: %v, want: %v", stats, want)
+	}
+	if len(r.textures) != 2 {
+		t.Fatalf("have %d frames, want 2", len(r.textures))
+	}
+	var last mockTexture
+	ui.Figure(&last, Pt(0.9, 0.5).Resize(FrameSize).ToImage())
+	if have := r.textures[1].(*mockTexture).rects; !slices.Equal(have, last.rects) {
+		t.Errorf("last frame does not show the last state: have: %v, want: %v", have, last.rects)
+	}
+}
+
+func TestFrameTimer(t *testing.T) {
+	var (
+		l      = NewLoop()
+		r      mockReceiver
+		frames = make(chan FrameStats, 10)
+	)
+	l.Receiver = &r
+	l.FrameInterval = 20 * time.Millisecond
+	l.OnFrame = func(s FrameStats) { frames <- s }
+	go l.Start(mockScreen{})
+
+	l.Post(Update)
+	l.Post(Update)
 	l.Post(Update)
 
-	if len(r.textures) != 0 {
-		t.Errorf("update has an effect after closing")
+	if s := <-frames; s.Coalesced != 0 {
+		t.Errorf("first frame: have: %+v", s)
+	}
+	select {
+	case s := <-frames:
+		if s.Coalesced != 1 {
+			t.Errorf("delayed frame: have: %+v, want 1 coalesced update", s)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("delayed frame was not rendered by the timer")
 	}
+	l.StopAndWait()
 }
 
-func TestMove(t *testing.T) {
+// slowReceiver blocks in Update until a value is sent to release.
+type slowReceiver struct {
+	started chan screen.Texture
+	release chan struct{}
+
+	mu     sync.Mutex
+	frames []screen.Texture
+}
+
+func newSlowReceiver() *slowReceiver {
+	return &slowReceiver{
+		started: make(chan screen.Texture, 100),
+		release: make(chan struct{}),
+	}
+}
+
+func (sr *slowReceiver) Update(t screen.Texture) {
+	sr.started <- t
+	<-sr.release
+	sr.mu.Lock()
+	sr.frames = append(sr.frames, t)
+	sr.mu.Unlock()
+}
+
+func TestSubscribers(t *testing.T) {
+	for _, tc := range []struct {
+		policy DeliveryPolicy
+		want   int // Кадри, які отримає повільний підписник.
+	}{
+		{LatestWins, 2},
+		{Drop, 1},
+	} {
+		var (
+			l      = NewLoop()
+			r      mockReceiver
+			s      countingScreen
+			slow   = newSlowReceiver()
+			frames = make(chan FrameStats, 200)
+		)
+		l.Receiver = &r
+		l.OnFrame = func(s FrameStats) { frames <- s }
+		l.Subscribe(slow, tc.policy)
+		go l.Start(&s)
+
+		for i := range 100 {
+			l.Post(NamedFigure("a", Pt(float32(i)/100, 0.5)))
+			l.Post(Update)
+		}
+		first := <-slow.started
+		// Повільний підписник не зупиняє цикл.
+		l.Post(WhiteFill)
+		l.Post(Update)
+		for range 101 {
+			<-frames
+		}
+		close(slow.release)
+		for range tc.want - 1 {
+			<-slow.started
+		}
+		l.StopAndWait()
+
+		if len(slow.frames) != tc.want {
+			t.Errorf("%v: slow subscriber frames: have: %d, want: %d", tc.policy, len(slow.frames), tc.want)
+		}
+		if slow.frames[0] != first {
+			t.Errorf("%v: first frame changed", tc.policy)
+		}
+		if m := s.max.Load(); m > 4 {
+			t.Errorf("%v: live textures: have max: %d, want at most 4", tc.policy, m)
+		}
+		if n := s.live.Load(); n != 0 {
+			t.Errorf("%v: textures are not released after stop, live: %d", tc.policy, n)
+		}
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	var (
+		l      = NewLoop()
+		r1, r2 mockReceiver
+		frames = make(chan FrameStats, 2)
+	)
+	l.OnFrame = func(s FrameStats) { frames <- s }
+	l.Subscribe(&r1, Blocking)
+	l.Subscribe(&r2, Blocking)
+	go l.Start(mockScreen{})
+
+	l.Post(Update)
+	<-frames
+	l.Unsubscribe(&r1)
+	l.Post(Update)
+	l.StopAndWait()
+
+	if len(r1.textures) != 1 || len(r2.textures) != 2 {
+		t.Errorf("frames after unsubscribe: have: %d and %d, want: 1 and 2", len(r1.textures), len(r2.textures))
+	}
+	if err := l.Subscribe(&r1, LatestWins); !errors.Is(err, ErrLoopStopped) {
+		t.Errorf("Subscribe() after stop err = %v, want: %v", err, ErrLoopStopped)
+	}
+}
+
+func TestUnsubscribeWaitsForUpdate(t *testing.T) {
+	var (
+		l    = NewLoop()
+		slow = newSlowReceiver()
+		done = make(chan struct{})
+	)
+	l.Subscribe(slow, Blocking)
+	go l.Start(mockScreen{})
+	defer l.StopAndWait()
+
+	l.Post(Update)
+	<-slow.started
+	go func() {
+		l.Unsubscribe(slow)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("Unsubscribe returned while Update was running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(slow.release)
+	<-done
+	if len(slow.frames) != 1 {
+		t.Errorf("frames: have: %d, want: 1", len(slow.frames))
+	}
+}
+
+func TestReceiverPanics(t *testing.T) {
+	var (
+		l       = NewLoop()
+		s       countingScreen
+		mu      sync.Mutex
+		errs    []error
+		stopped = make(chan struct{})
+	)
+	l.OnError = func(err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	}
+	l.Subscribe(&panicReceiver{}, Blocking)
+	async := &panicReceiver{}
+	l.Subscribe(async, LatestWins)
+	go l.Start(&s)
+
+	l.Post(Update)
+	// Паніку асинхронного отримувача цикл повідомляє сам, дочекаємося її.
+	for {
+		mu.Lock()
+		n := len(errs)
+		mu.Unlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	go func() {
+		l.StopAndWait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("StopAndWait hangs after a receiver panic")
+	}
+
+	for _, err := range errs {
+		var pe *PanicError
+		if !errors.As(err, &pe) || pe.Value != "broken receiver" {
+			t.Errorf("reported error: have: %v, want *PanicError", err)
+		}
+	}
+	if n := s.live.Load(); n != 0 {
+		t.Errorf("textures are not released after the panics, live: %d", n)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	l := NewLoop()
+	go l.Start(mockScreen{})
+
+	l.Post(OperationList{NamedBgRect("r", Rect(0, 0, 1, 1), color.White), NamedFigure("a", Pt(0.5, 0.5))})
+	snap, err := l.Snapshot(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Post(OperationList{ClearBgRects, Move("a", Pt(0, 0))})
+	later, err := l.Snapshot(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.StopAndWait()
+
+	if rects := snap.Rects(); len(rects) != 1 || rects[0].ID != "r" {
+		t.Errorf("rects: have: %v, want one rect r", rects)
+	}
+	snap.Rects()[0].ID = "changed"
+	if snap.Rects()[0].ID != "r" {
+		t.Error("snapshot is changed through Rects")
+	}
+	if f := snap.Figures(); len(f) != 1 || f[0].Pos != Pt(0.5, 0.5) {
+		t.Errorf("figures: have: %v, want a at (0.5, 0.5)", f)
+	}
+	if len(later.Rects()) != 0 || later.Figures()[0].Pos != Pt(0, 0) {
+		t.Errorf("later snapshot: have: %v %v", later.Rects(), later.Figures())
+	}
+
+	if _, err := l.Snapshot(context.Background()); !errors.Is(err, ErrLoopStopped) {
+		t.Errorf("snapshot of stopped loop: have: %v, want: %v", err, ErrLoopStopped)
+	}
+}
+
+func TestUndoRedo(t *testing.T) {
 	var (
 		l = NewLoop()
 		r mockReceiver
-
-		rect = Rect(1, 1, 1, 1)
-		move = Pt(2, 2)
 	)
 	l.Receiver = &r
 	go l.Start(mockScreen{})
 
-	l.Post(BgRect(rect))
-	l.Post(Move(move))
+	l.Post(OperationList{WhiteFill, Update})
+	// Окремі операції без оновлення потрапляють в історію разом з наступним.
+	l.Post(GreenFill)
+	l.Post(BgRect(Rect(0, 0, 0.5, 0.5)))
 	l.Post(Update)
+	l.Post(Undo)
+	l.Post(Redo)
+	l.Post(Undo)
+	l.Post(Undo)
+	l.Post(Undo) // Історія вже порожня.
+	l.Post(Redo)
+	l.StopAndWait()
+
+	want := []struct {
+		bg    color.Color
+		rects int
+	}{
+		{color.White, 0},
+		{GreenFill.Color, 1},
+		{color.White, 0},
+		{GreenFill.Color, 1},
+		{color.White, 0},
+		{color.Black, 0},
+		{color.White, 0},
+	}
+	if len(r.textures) != len(want) {
+		t.Fatalf("frames: have: %d, want: %d", len(r.textures), len(want))
+	}
+	for i, w := range want {
+		tx := r.textures[i].(*mockTexture)
+		if !isColorsEqual(tx.bgColor, w.bg) || len(tx.rects) != w.rects {
+			t.Errorf("frame %d: have: %v with %d rects, want: %v with %d rects", i, tx.bgColor, len(tx.rects), w.bg, w.rects)
+		}
+	}
+}
 
+func TestUndoKeepsFigureIDs(t *testing.T) {
+	l := NewLoop()
+	go l.Start(mockScreen{})
+	l.Post(OperationList{Figure(Pt(0.1, 0.1)), Update})
+	l.Post(Undo)
+	l.Post(OperationList{Figure(Pt(0.2, 0.2)), Update})
+	snap, err := l.Snapshot(context.Background())
 	l.StopAndWait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Скасована фігура f1 могла бути відома клієнтам, тож її ID не повторюється.
+	if f := snap.Figures(); len(f) != 1 || f[0].ID != "f2" {
+		t.Errorf("figures after undo: have: %v, want: [f2]", f)
+	}
+}
 
-	texture := r.textures[0].(*mockTexture)
-	if texture.rects[0] != rect.Resize(size).ToImage() {
-		t.Errorf("move has an effect on BgRect")
+func TestHistoryDepth(t *testing.T) {
+	l := NewLoop()
+	l.HistoryDepth = 2
+	go l.Start(mockScreen{})
+
+	for i := range 5 {
+		l.Post(OperationList{NamedFigure("a", Pt(float32(i)/10, 0)), Update})
+	}
+	info, _ := l.History(context.Background())
+	if want := (HistoryInfo{Depth: 2, Undo: 2}); info != want {
+		t.Errorf("history: have: %+v, want: %+v", info, want)
+	}
+
+	l.Post(Undo)
+	l.Post(Undo)
+	l.Post(Undo)
+	snap, _ := l.Snapshot(context.Background())
+	if f := snap.Figures(); len(f) != 1 || f[0].Pos != Pt(0.2, 0) {
+		t.Errorf("figures after undo: have: %v, want a at (0.2, 0)", f)
+	}
+
+	l.Post(SetHistoryDepth(1))
+	info, _ = l.History(context.Background())
+	if want := (HistoryInfo{Depth: 1, Redo: 1}); info != want {
+		t.Errorf("history after depth change: have: %+v, want: %+v", info, want)
+	}
+
+	l.Post(SetHistoryDepth(0))
+	l.Post(OperationList{Reset, Update})
+	l.Post(Undo)
+	snap, _ = l.Snapshot(context.Background())
+	l.StopAndWait()
+	if f := snap.Figures(); len(f) != 0 {
+		t.Errorf("undo with zero depth changed the state: figures: %v", f)
+	}
+}
+
+func TestApply(t *testing.T) {
+	var (
+		l    = NewLoop()
+		r    mockReceiver
+		errs []error
+	)
+	l.Receiver = &r
+	l.OnError = func(err error) { errs = append(errs, err) }
+	go l.Start(mockScreen{})
+
+	if err := l.Apply(context.Background(), OperationList{NamedFigure("a", Pt(0.5, 0.5)), Update}); err != nil {
+		t.Fatal(err)
+	}
+	err := l.Apply(context.Background(), OperationList{Reset, Undo, Shift("b", Pt(0.1, 0)), Update})
+	if !errors.Is(err, ErrUnknownFigure) {
+		t.Errorf("Apply() err = %v, want: %v", err, ErrUnknownFigure)
+	}
+	info, _ := l.History(context.Background())
+	snap, _ := l.Snapshot(context.Background())
+	applyErrs, applyErr := len(errs), l.Err()
+	// Та сама помилка через Post нікому не повертається, тож її повідомляють.
+	l.Post(Shift("b", Pt(0.1, 0)))
+	l.StopAndWait()
+
+	if info.Undo != 1 || info.Redo != 0 {
+		t.Errorf("history is changed by a failed list: %+v", info)
+	}
+	if f := snap.Figures(); len(f) != 1 || f[0].ID != "a" {
+		t.Errorf("state is changed by a failed list: figures %v", f)
+	}
+	if len(r.textures) != 1 {
+		t.Errorf("frames: have: %d, want: 1", len(r.textures))
+	}
+	if applyErrs != 0 || applyErr != nil {
+		t.Errorf("failure returned by Apply is reported: errors %v, Err() = %v", errs[:applyErrs], applyErr)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], ErrUnknownFigure) {
+		t.Errorf("reported errors: have: %v, want one %v", errs, ErrUnknownFigure)
+	}
+	if err := l.Apply(context.Background(), Update); !errors.Is(err, ErrLoopStopped) {
+		t.Errorf("Apply() on stopped loop err = %v, want: %v", err, ErrLoopStopped)
 	}
 }
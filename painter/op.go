@@ -1,65 +1,574 @@
 package painter
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"image/color"
+	"strings"
 )
 
+// Operation changes the state. It returns ready if the state must be shown.
+// An operation that fails leaves the state unchanged.
 type Operation interface {
-	Do(state *textureState) (ready bool)
+	Do(state *State) (ready bool, err error)
 }
 
+var (
+	ErrUnknownFigure = errors.New("unknown figure")
+	ErrUnknownRect   = errors.New("unknown rectangle")
+)
+
+// OperationList applies operations in order. If one of them fails, the
+// state is restored to its value before the list.
 type OperationList []Operation
 
-func (ol OperationList) Do(t *textureState) (ready bool) {
-	for _, o := range ol {
-		ready = o.Do(t) || ready
+func (ol OperationList) Do(s *State) (ready bool, err error) {
+	before := s.Clone()
+	for i, o := range ol {
+		r, err := o.Do(s)
+		if err != nil {
+			*s = *before
+			return false, &OpError{Index: i, Op: o, Err: err}
+		}
+		ready = r || ready
+	}
+	return ready, nil
+}
+
+// OpError reports the operation of a list that failed.
+type OpError struct {
+	Index int // Позиція операції у списку.
+	Op    Operation
+	Err   error
+}
+
+func (e *OpError) Error() string {
+	return fmt.Sprintf("operation %d (%v): %v", e.Index+1, e.Op, e.Err)
+}
+
+func (e *OpError) Unwrap() error { return e.Err }
+
+func (ol OperationList) String() string {
+	lines := make([]string, len(ol))
+	for i, o := range ol {
+		lines[i] = fmt.Sprint(o)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (ol OperationList) Equal(op Operation) bool {
+	other, ok := op.(OperationList)
+	if !ok || len(ol) != len(other) {
+		return false
+	}
+	for i := range ol {
+		if !EqualOps(ol[i], other[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (ol *OperationList) UnmarshalJSON(data []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	res := make(OperationList, len(raw))
+	for i, r := range raw {
+		op, err := UnmarshalOperation(r)
+		if err != nil {
+			return err
+		}
+		res[i] = op
+	}
+	*ol = res
+	return nil
+}
+
+type OperationFunc func(s *State) error
+
+func (f OperationFunc) Do(s *State) (bool, error) {
+	return false, f(s)
+}
+
+// EqualOps reports whether both operations are built-in operations with the
+// same parameters. Operations without an Equal method are never equal.
+func EqualOps(op1, op2 Operation) bool {
+	e, ok := op1.(interface{ Equal(Operation) bool })
+	return ok && e.Equal(op2)
+}
+
+type UpdateOp struct{}
+
+var Update = UpdateOp{}
+
+func (op UpdateOp) Do(s *State) (bool, error) { return true, nil }
+
+func (op UpdateOp) String() string { return "update" }
+
+func (op UpdateOp) Equal(other Operation) bool {
+	_, ok := other.(UpdateOp)
+	return ok
+}
+
+func (op UpdateOp) MarshalJSON() ([]byte, error) { return marshalOp("update", struct{}{}) }
+
+func (op *UpdateOp) UnmarshalJSON(data []byte) error { return nil }
+
+type FillOp struct {
+	Color color.Color
+}
+
+func Fill(c color.Color) FillOp {
+	return FillOp{c}
+}
+
+var WhiteFill = Fill(color.White)
+
+var GreenFill = Fill(color.RGBA{G: 0xff, A: 0xff})
+
+func (op FillOp) Do(s *State) (bool, error) {
+	if op.Color == nil {
+		return false, ErrBadColor
+	}
+	s.SetBackground(op.Color)
+	return false, nil
+}
+
+func (op FillOp) String() string {
+	switch {
+	case isColorsEqual(op.Color, WhiteFill.Color):
+		return "white"
+	case isColorsEqual(op.Color, GreenFill.Color):
+		return "green"
+	}
+	return "fill " + FormatColor(op.Color)
+}
+
+func (op FillOp) Equal(other Operation) bool {
+	o, ok := other.(FillOp)
+	return ok && isColorsEqual(op.Color, o.Color)
+}
+
+func (op FillOp) MarshalJSON() ([]byte, error) {
+	return marshalOp("fill", struct {
+		Color jsonColor `json:"color"`
+	}{jsonColor{op.Color}})
+}
+
+func (op *FillOp) UnmarshalJSON(data []byte) error {
+	var v struct {
+		Color jsonColor `json:"color"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	op.Color = v.Color.Color
+	return nil
+}
+
+// BgRectOp adds a rectangle on top of the background. A rectangle with the
+// same non-empty ID is replaced in place.
+type BgRectOp struct {
+	ID    string
+	Rect  Rectangle
+	Color color.Color
+}
+
+func BgRect(coords Rectangle) BgRectOp {
+	return BgRectColor(coords, color.Black)
+}
+
+func BgRectColor(coords Rectangle, c color.Color) BgRectOp {
+	return NamedBgRect("", coords, c)
+}
+
+func NamedBgRect(id string, coords Rectangle, c color.Color) BgRectOp {
+	return BgRectOp{id, coords, c}
+}
+
+func (op BgRectOp) Do(s *State) (bool, error) {
+	if err := op.Rect.check(); err != nil {
+		return false, err
+	}
+	if op.Color == nil {
+		return false, ErrBadColor
+	}
+	s.AddRect(RectItem{op.ID, op.Rect, op.Color})
+	return false, nil
+}
+
+func (op BgRectOp) String() string {
+	res := fmt.Sprintf("bgrect %s %s", formatPoint(op.Rect.Min), formatPoint(op.Rect.Max))
+	if !isColorsEqual(op.Color, color.Black) {
+		res += " " + FormatColor(op.Color)
+	}
+	if op.ID != "" {
+		res += " id=" + op.ID
+	}
+	return res
+}
+
+func (op BgRectOp) Equal(other Operation) bool {
+	o, ok := other.(BgRectOp)
+	return ok && op.ID == o.ID && op.Rect == o.Rect && isColorsEqual(op.Color, o.Color)
+}
+
+func (op BgRectOp) MarshalJSON() ([]byte, error) {
+	return marshalOp("bgrect", jsonBgRect{op.ID, op.Rect, jsonColor{op.Color}})
+}
+
+func (op *BgRectOp) UnmarshalJSON(data []byte) error {
+	var v jsonBgRect
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*op = BgRectOp{v.ID, v.Rect, v.Color.Color}
+	return nil
+}
+
+type jsonBgRect struct {
+	ID    string    `json:"id,omitempty"`
+	Rect  Rectangle `json:"rect"`
+	Color jsonColor `json:"color"`
+}
+
+type RemoveBgRectOp struct {
+	ID string
+}
+
+func RemoveBgRect(id string) RemoveBgRectOp {
+	return RemoveBgRectOp{id}
+}
+
+func (op RemoveBgRectOp) Do(s *State) (bool, error) {
+	if !s.RemoveRect(op.ID) {
+		return false, fmt.Errorf("%w: %s", ErrUnknownRect, op.ID)
+	}
+	return false, nil
+}
+
+func (op RemoveBgRectOp) String() string { return "bgrect-remove " + op.ID }
+
+func (op RemoveBgRectOp) Equal(other Operation) bool {
+	o, ok := other.(RemoveBgRectOp)
+	return ok && op == o
+}
+
+func (op RemoveBgRectOp) MarshalJSON() ([]byte, error) {
+	return marshalOp("bgrect-remove", jsonID{op.ID})
+}
+
+func (op *RemoveBgRectOp) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, (*jsonID)(op))
+}
+
+type ClearBgRectsOp struct{}
+
+var ClearBgRects = ClearBgRectsOp{}
+
+func (op ClearBgRectsOp) Do(s *State) (bool, error) {
+	s.ClearRects()
+	return false, nil
+}
+
+func (op ClearBgRectsOp) String() string { return "bgrect-clear" }
+
+func (op ClearBgRectsOp) Equal(other Operation) bool {
+	_, ok := other.(ClearBgRectsOp)
+	return ok
+}
+
+func (op ClearBgRectsOp) MarshalJSON() ([]byte, error) {
+	return marshalOp("bgrect-clear", struct{}{})
+}
+
+func (op *ClearBgRectsOp) UnmarshalJSON(data []byte) error { return nil }
+
+// FigureOp adds a figure. If the ID is already taken, the figure is moved
+// instead; an empty ID is replaced with a new one of "f1", "f2", ... as in
+// State.AddFigure.
+type FigureOp struct {
+	ID  string
+	Pos Point
+}
+
+func Figure(coords Point) FigureOp {
+	return NamedFigure("", coords)
+}
+
+func NamedFigure(id string, coords Point) FigureOp {
+	return FigureOp{id, coords}
+}
+
+func (op FigureOp) Do(s *State) (bool, error) {
+	if err := op.Pos.check(); err != nil {
+		return false, err
 	}
-	return
+	s.AddFigure(FigureItem{op.ID, op.Pos})
+	return false, nil
 }
 
-type updateOp struct{}
+func (op FigureOp) String() string {
+	if op.ID == "" {
+		return "figure " + formatPoint(op.Pos)
+	}
+	return fmt.Sprintf("figure %s id=%s", formatPoint(op.Pos), op.ID)
+}
 
-func (op updateOp) Do(s *textureState) bool { return true }
+func (op FigureOp) Equal(other Operation) bool {
+	o, ok := other.(FigureOp)
+	return ok && op == o
+}
 
-var Update = updateOp{}
+func (op FigureOp) MarshalJSON() ([]byte, error) {
+	return marshalOp("figure", jsonFigure(op))
+}
 
-type OperationFunc func(s *textureState)
+func (op *FigureOp) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, (*jsonFigure)(op))
+}
 
-func (f OperationFunc) Do(s *textureState) bool {
-	f(s)
-	return false
+type jsonFigure struct {
+	ID  string `json:"id,omitempty"`
+	Pos Point  `json:"pos"`
 }
 
-var WhiteFill OperationFunc = func(s *textureState) {
-	s.background.color = color.White
+type MoveOp struct {
+	ID  string
+	Pos Point
 }
 
-var GreenFill OperationFunc = func(s *textureState) {
-	s.background.color = color.RGBA{G: 0xff, A: 0xff}
+func Move(id string, coords Point) MoveOp {
+	return MoveOp{id, coords}
 }
 
-func BgRect(coords Rectangle) OperationFunc {
-	return func(s *textureState) {
-		s.background.rect = &coords
+func (op MoveOp) Do(s *State) (bool, error) {
+	if err := op.Pos.check(); err != nil {
+		return false, err
+	}
+	if !s.MoveFigure(op.ID, op.Pos) {
+		return false, fmt.Errorf("%w: %s", ErrUnknownFigure, op.ID)
 	}
+	return false, nil
+}
+
+func (op MoveOp) String() string {
+	return fmt.Sprintf("move %s %s", op.ID, formatPoint(op.Pos))
+}
+
+func (op MoveOp) Equal(other Operation) bool {
+	o, ok := other.(MoveOp)
+	return ok && op == o
 }
 
-func Figure(coords Point) OperationFunc {
-	return func(s *textureState) {
-		s.figures = append(s.figures, coords)
+func (op MoveOp) MarshalJSON() ([]byte, error) {
+	return marshalOp("move", jsonFigure(op))
+}
+
+func (op *MoveOp) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, (*jsonFigure)(op))
+}
+
+type ShiftOp struct {
+	ID    string
+	Delta Point
+}
+
+func Shift(id string, delta Point) ShiftOp {
+	return ShiftOp{id, delta}
+}
+
+func (op ShiftOp) Do(s *State) (bool, error) {
+	f, ok := s.Figure(op.ID)
+	if !ok {
+		return false, fmt.Errorf("%w: %s", ErrUnknownFigure, op.ID)
+	}
+	pos := Pt(f.Pos.X+op.Delta.X, f.Pos.Y+op.Delta.Y)
+	if err := pos.check(); err != nil {
+		return false, err
 	}
+	s.MoveFigure(op.ID, pos)
+	return false, nil
+}
+
+func (op ShiftOp) String() string {
+	return fmt.Sprintf("shift %s %s", op.ID, formatPoint(op.Delta))
+}
+
+func (op ShiftOp) Equal(other Operation) bool {
+	o, ok := other.(ShiftOp)
+	return ok && op == o
+}
+
+func (op ShiftOp) MarshalJSON() ([]byte, error) {
+	return marshalOp("shift", jsonShift(op))
+}
+
+func (op *ShiftOp) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, (*jsonShift)(op))
+}
+
+type jsonShift struct {
+	ID    string `json:"id"`
+	Delta Point  `json:"delta"`
+}
+
+type RemoveFigureOp struct {
+	ID string
 }
 
-func Move(coords Point) OperationFunc {
-	return func(s *textureState) {
-		for i := range s.figures {
-			s.figures[i] = coords
+func RemoveFigure(id string) RemoveFigureOp {
+	return RemoveFigureOp{id}
+}
+
+func (op RemoveFigureOp) Do(s *State) (bool, error) {
+	if !s.RemoveFigure(op.ID) {
+		return false, fmt.Errorf("%w: %s", ErrUnknownFigure, op.ID)
+	}
+	return false, nil
+}
+
+func (op RemoveFigureOp) String() string { return "remove " + op.ID }
+
+func (op RemoveFigureOp) Equal(other Operation) bool {
+	o, ok := other.(RemoveFigureOp)
+	return ok && op == o
+}
+
+func (op RemoveFigureOp) MarshalJSON() ([]byte, error) {
+	return marshalOp("remove", jsonID{op.ID})
+}
+
+func (op *RemoveFigureOp) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, (*jsonID)(op))
+}
+
+type MoveAllOp struct {
+	Pos Point
+}
+
+func MoveAll(coords Point) MoveAllOp {
+	return MoveAllOp{coords}
+}
+
+func (op MoveAllOp) Do(s *State) (bool, error) {
+	if err := op.Pos.check(); err != nil {
+		return false, err
+	}
+	s.MoveAllFigures(op.Pos)
+	return false, nil
+}
+
+func (op MoveAllOp) String() string { return "move-all " + formatPoint(op.Pos) }
+
+func (op MoveAllOp) Equal(other Operation) bool {
+	o, ok := other.(MoveAllOp)
+	return ok && op == o
+}
+
+func (op MoveAllOp) MarshalJSON() ([]byte, error) {
+	return marshalOp("move-all", jsonPos(op))
+}
+
+func (op *MoveAllOp) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, (*jsonPos)(op))
+}
+
+type jsonPos struct {
+	Pos Point `json:"pos"`
+}
+
+type ResetOp struct{}
+
+var Reset = ResetOp{}
+
+func (op ResetOp) Do(s *State) (bool, error) {
+	s.Reset()
+	return false, nil
+}
+
+func (op ResetOp) String() string { return "reset" }
+
+func (op ResetOp) Equal(other Operation) bool {
+	_, ok := other.(ResetOp)
+	return ok
+}
+
+func (op ResetOp) MarshalJSON() ([]byte, error) { return marshalOp("reset", struct{}{}) }
+
+func (op *ResetOp) UnmarshalJSON(data []byte) error { return nil }
+
+type jsonID struct {
+	ID string `json:"id"`
+}
+
+var ErrUnknownOperation = errors.New("unknown operation")
+
+var opDecoders = map[string]func(data []byte) (Operation, error){
+	"update":        decodeOp[UpdateOp],
+	"fill":          decodeOp[FillOp],
+	"bgrect":        decodeOp[BgRectOp],
+	"bgrect-remove": decodeOp[RemoveBgRectOp],
+	"bgrect-clear":  decodeOp[ClearBgRectsOp],
+	"figure":        decodeOp[FigureOp],
+	"move":          decodeOp[MoveOp],
+	"shift":         decodeOp[ShiftOp],
+	"remove":        decodeOp[RemoveFigureOp],
+	"move-all":      decodeOp[MoveAllOp],
+	"reset":         decodeOp[ResetOp],
+	"undo":          decodeOp[UndoOp],
+	"redo":          decodeOp[RedoOp],
+	"history":       decodeOp[HistoryDepthOp],
+}
+
+// UnmarshalOperation decodes an operation written by its MarshalJSON method.
+// A JSON array is decoded as OperationList.
+func UnmarshalOperation(data []byte) (Operation, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		var list OperationList
+		if err := list.UnmarshalJSON(trimmed); err != nil {
+			return nil, err
 		}
+		return list, nil
+	}
+
+	var head struct {
+		Op string `json:"op"`
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		return nil, err
 	}
+	decode, ok := opDecoders[head.Op]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownOperation, head.Op)
+	}
+	return decode(data)
+}
+
+func decodeOp[T Operation, PT interface {
+	*T
+	json.Unmarshaler
+}](data []byte) (Operation, error) {
+	var op T
+	if err := PT(&op).UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+	return op, nil
 }
 
-var Reset OperationFunc = func(s *textureState) {
-	s.background.color = color.Black
-	s.background.rect = nil
-	s.figures = s.figures[:0]
+// marshalOp writes fields as a JSON object with an extra "op" name field.
+func marshalOp(name string, fields any) ([]byte, error) {
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	head := fmt.Sprintf(`{"op":%q`, name)
+	if string(b) == "{}" {
+		return []byte(head + "}"), nil
+	}
+	return append([]byte(head+","), b[1:]...), nil
 }
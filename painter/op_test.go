@@ -0,0 +1,99 @@
+package painter
+
+import (
+	"encoding/json"
+	"errors"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestOperationJSON(t *testing.T) {
+	ops := OperationList{
+		Update,
+		Fill(color.NRGBA{R: 0x10, G: 0x20, B: 0x30, A: 0x40}),
+		NamedBgRect("a", Rect(0.1, 0.2, 0.3, 0.4), color.White),
+		RemoveBgRect("a"),
+		ClearBgRects,
+		OperationList{Figure(Pt(0.5, 0.5)), NamedFigure("b", Pt(0.1, 0.1))},
+		Move("b", Pt(0.2, 0.2)),
+		Shift("b", Pt(-0.1, 0)),
+		RemoveFigure("b"),
+		MoveAll(Pt(1, 1)),
+		Reset,
+		Undo,
+		Redo,
+		SetHistoryDepth(10),
+	}
+
+	data, err := json.Marshal(ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	op, err := UnmarshalOperation(data)
+	if err != nil {
+		t.Fatalf("UnmarshalOperation(%s) err = %v", data, err)
+	}
+	if !ops.Equal(op) {
+		t.Errorf("UnmarshalOperation(%s) = %v, want: %v", data, op, ops)
+	}
+}
+
+func TestOperationJSONErrors(t *testing.T) {
+	if _, err := UnmarshalOperation([]byte(`{"op":"draw"}`)); !errors.Is(err, ErrUnknownOperation) {
+		t.Errorf("unknown op: err = %v, want: %v", err, ErrUnknownOperation)
+	}
+	if _, err := UnmarshalOperation([]byte(`{"op":"fill","color":"nope"}`)); !errors.Is(err, ErrBadColor) {
+		t.Errorf("bad color: err = %v, want: %v", err, ErrBadColor)
+	}
+	if _, err := json.Marshal(OperationList{OperationFunc(func(*State) error { return nil })}); err == nil {
+		t.Errorf("OperationFunc must not be serializable")
+	}
+}
+
+func TestOperationString(t *testing.T) {
+	cases := []struct {
+		op   Operation
+		want string
+	}{
+		{WhiteFill, "white"},
+		{Fill(color.NRGBA{R: 0xff, A: 0x80}), "fill #ff000080"},
+		{BgRect(Rect(0.1, 0.2, 0.3, 0.4)), "bgrect 0.1 0.2 0.3 0.4"},
+		{NamedBgRect("a", Rect(0, 0, 1, 1), color.White), "bgrect 0 0 1 1 #ffffff id=a"},
+		{Figure(Pt(0.5, 0.5)), "figure 0.5 0.5"},
+		{Move("f1", Pt(0.25, 0.5)), "move f1 0.25 0.5"},
+		{OperationList{Reset, Update}, "reset\nupdate"},
+	}
+	for _, tc := range cases {
+		if have := tc.op.(interface{ String() string }).String(); have != tc.want {
+			t.Errorf("String() = %q, want: %q", have, tc.want)
+		}
+	}
+}
+
+func TestOperationListRollback(t *testing.T) {
+	s := NewState()
+	if _, err := NamedFigure("a", Pt(0.5, 0.5)).Do(s); err != nil {
+		t.Fatal(err)
+	}
+	before := s.Clone()
+
+	_, err := OperationList{WhiteFill, Move("a", Pt(0, 0)), RemoveFigure("b"), Update}.Do(s)
+	var opErr *OpError
+	if !errors.As(err, &opErr) || opErr.Index != 2 || !errors.Is(err, ErrUnknownFigure) {
+		t.Errorf("Do() err = %v, want OpError for operation 2 with %v", err, ErrUnknownFigure)
+	}
+	if !s.Equal(*before) {
+		t.Errorf("state is changed by a failed list")
+	}
+
+	nan := float32(math.NaN())
+	for _, op := range []Operation{Move("a", Pt(nan, 0)), BgRect(Rect(0, 0, nan, 1)), Shift("a", Pt(float32(math.Inf(1)), 0))} {
+		if _, err := op.Do(s); !errors.Is(err, ErrBadCoordinates) {
+			t.Errorf("%v: err = %v, want: %v", op, err, ErrBadCoordinates)
+		}
+	}
+	if _, err := RemoveBgRect("x").Do(s); !errors.Is(err, ErrUnknownRect) {
+		t.Errorf("RemoveBgRect: err = %v, want: %v", err, ErrUnknownRect)
+	}
+}
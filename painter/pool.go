@@ -0,0 +1,79 @@
+package painter
+
+import (
+	"image"
+	"sync"
+
+	"golang.org/x/exp/shiny/screen"
+)
+
+// texturePool reuses textures nobody holds anymore instead of allocating a
+// new one for every frame. The loop and every receiver that got a texture
+// hold a reference to it; the texture is reused when the last one is
+// released.
+type texturePool struct {
+	mu   sync.Mutex
+	s    screen.Screen
+	size image.Point
+	free []screen.Texture
+	refs map[screen.Texture]int
+}
+
+// get returns a texture with a single reference owned by the caller.
+func (p *texturePool) get() (screen.Texture, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var t screen.Texture
+	if n := len(p.free); n > 0 {
+		t = p.free[n-1]
+		p.free = p.free[:n-1]
+	} else {
+		var err error
+		if t, err = p.s.NewTexture(p.size); err != nil || t == nil {
+			return nil, err
+		}
+	}
+	if p.refs == nil {
+		p.refs = make(map[screen.Texture]int)
+	}
+	p.refs[t] = 1
+	return t, nil
+}
+
+func (p *texturePool) retain(t screen.Texture) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.refs[t]; ok {
+		p.refs[t]++
+	}
+}
+
+func (p *texturePool) put(t screen.Texture) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n, ok := p.refs[t]
+	if !ok {
+		return // Пул уже звільнено.
+	}
+	if n > 1 {
+		p.refs[t] = n - 1
+		return
+	}
+	delete(p.refs, t)
+	p.free = append(p.free, t)
+}
+
+// release frees every texture, including the ones still referenced.
+func (p *texturePool) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, t := range p.free {
+		t.Release()
+	}
+	for t := range p.refs {
+		t.Release()
+	}
+	p.free = nil
+	p.refs = nil
+}
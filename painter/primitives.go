@@ -1,9 +1,18 @@
 package painter
 
-import "image"
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+)
+
+var ErrBadCoordinates = errors.New("coordinates must be finite numbers")
 
 type Point struct {
-	X, Y float32
+	X float32 `json:"x"`
+	Y float32 `json:"y"`
 }
 
 func Pt(x, y float32) Point {
@@ -18,8 +27,26 @@ func (p Point) ToImage() image.Point {
 	return image.Pt(int(p.X), int(p.Y))
 }
 
+func (p Point) check() error {
+	if isFinite(p.X) && isFinite(p.Y) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrBadCoordinates, formatPoint(p))
+}
+
+func isFinite(f float32) bool {
+	return !math.IsNaN(float64(f)) && !math.IsInf(float64(f), 0)
+}
+
+// formatPoint writes the shortest coordinates that parse back to the same point.
+func formatPoint(p Point) string {
+	return strconv.FormatFloat(float64(p.X), 'g', -1, 32) + " " +
+		strconv.FormatFloat(float64(p.Y), 'g', -1, 32)
+}
+
 type Rectangle struct {
-	Min, Max Point
+	Min Point `json:"min"`
+	Max Point `json:"max"`
 }
 
 func Rect(x0, y0, x1, y1 float32) Rectangle {
@@ -38,3 +65,10 @@ func (r Rectangle) ToImage() image.Rectangle {
 		Max: r.Max.ToImage(),
 	}
 }
+
+func (r Rectangle) check() error {
+	if err := r.Min.check(); err != nil {
+		return err
+	}
+	return r.Max.check()
+}
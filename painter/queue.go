@@ -0,0 +1,202 @@
+package painter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Priority selects the lane of the message queue. The loop always takes
+// the next message from the highest non-empty lane, and messages within a
+// lane keep their order.
+type Priority int
+
+const (
+	PriorityBulk Priority = iota
+	PriorityInteractive
+	PriorityControl
+)
+
+var priorityNames = [...]string{
+	PriorityBulk:        "bulk",
+	PriorityInteractive: "interactive",
+	PriorityControl:     "control",
+}
+
+func (p Priority) String() string {
+	if p < 0 || int(p) >= len(priorityNames) {
+		return fmt.Sprintf("Priority(%d)", int(p))
+	}
+	return priorityNames[p]
+}
+
+func ParsePriority(s string) (Priority, error) {
+	for p, name := range priorityNames {
+		if name == s {
+			return Priority(p), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown priority: %q", s)
+}
+
+type prioritized struct {
+	msg      any
+	priority Priority
+}
+
+func (p prioritized) Do(s *State) (bool, error) {
+	if op, ok := p.msg.(Operation); ok {
+		return op.Do(s)
+	}
+	return false, nil
+}
+
+// WithPriority makes Post, TryPost and PostContext queue the operation to the
+// given lane. Inside an OperationList the priority has no effect.
+func WithPriority(op Operation, p Priority) Operation {
+	return prioritized{op, p}
+}
+
+// Unprioritized returns the operation passed to WithPriority, or op itself.
+func Unprioritized(op Operation) Operation {
+	if p, ok := op.(prioritized); ok {
+		if inner, ok := p.msg.(Operation); ok {
+			return inner
+		}
+	}
+	return op
+}
+
+// MarshalJSON writes the wrapped operation, the priority is not kept.
+func (p prioritized) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.msg)
+}
+
+type messageQueue struct {
+	lanes [len(priorityNames)]chan any
+	done  <-chan struct{} // Закривається, коли цикл завершився.
+
+	draining bool
+	left     [len(priorityNames)]int // Повідомлення, що лишилось взяти до зупинки.
+}
+
+func newMessageQueue(size int, done <-chan struct{}) messageQueue {
+	mq := messageQueue{done: done}
+	for i := range mq.lanes {
+		mq.lanes[i] = make(chan any, size)
+	}
+	return mq
+}
+
+// lane unwraps the message and returns the channel it belongs to.
+func (mq *messageQueue) lane(v any) (chan any, any, error) {
+	p := PriorityInteractive
+	if pv, ok := v.(prioritized); ok {
+		v, p = pv.msg, pv.priority
+	}
+	if p < 0 || int(p) >= len(mq.lanes) {
+		return nil, nil, fmt.Errorf("unknown priority: %v", p)
+	}
+	return mq.lanes[p], v, nil
+}
+
+func (mq *messageQueue) push(ctx context.Context, v any) error {
+	if mq.stopped() {
+		return ErrLoopStopped
+	}
+	lane, v, err := mq.lane(v)
+	if err != nil {
+		return err
+	}
+	select {
+	case lane <- v:
+		return nil
+	case <-mq.done:
+		return ErrLoopStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (mq *messageQueue) tryPush(v any) error {
+	if mq.stopped() {
+		return ErrLoopStopped
+	}
+	lane, v, err := mq.lane(v)
+	if err != nil {
+		return err
+	}
+	select {
+	case lane <- v:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+func (mq *messageQueue) stopped() bool {
+	select {
+	case <-mq.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// frameTick is returned by pull when the frame timer fires.
+type frameTick struct{}
+
+// drainMark is queued by StopAndWait. When pull takes it, the messages
+// already in the lanes are counted, and pull returns closeSignal once they
+// are taken. Messages queued later are never returned.
+type drainMark struct{}
+
+// pull waits for the next message. The tick is only taken when every lane is
+// empty, so pending operations are applied before a delayed frame.
+func (mq *messageQueue) pull(tick <-chan time.Time) any {
+	for {
+		if mq.draining && !slices.ContainsFunc(mq.left[:], func(n int) bool { return n > 0 }) {
+			return closeSignal{}
+		}
+		p, v := mq.next(tick)
+		if mq.draining && p >= 0 {
+			mq.left[p]--
+		}
+		if _, ok := v.(drainMark); !ok {
+			return v
+		}
+		if !mq.draining {
+			mq.draining = true
+			for i, lane := range mq.lanes {
+				mq.left[i] = len(lane)
+			}
+		}
+	}
+}
+
+// next returns the next message and its lane. While draining, only lanes
+// with messages left to take are read, and they are never empty.
+func (mq *messageQueue) next(tick <-chan time.Time) (Priority, any) {
+	for p := len(mq.lanes) - 1; p >= 0; p-- {
+		if mq.draining && mq.left[p] == 0 {
+			continue
+		}
+		select {
+		case v := <-mq.lanes[p]:
+			return Priority(p), v
+		default:
+		}
+	}
+	select {
+	case v := <-mq.lanes[PriorityControl]:
+		return PriorityControl, v
+	case v := <-mq.lanes[PriorityInteractive]:
+		return PriorityInteractive, v
+	case v := <-mq.lanes[PriorityBulk]:
+		return PriorityBulk, v
+	case <-tick:
+		return -1, frameTick{}
+	}
+}
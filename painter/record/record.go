@@ -0,0 +1,230 @@
+// Package record captures frames shown by the painter into an animated GIF
+// or a numbered PNG sequence.
+package record
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/roman-mazur/architecture-lab-3/ui/offscreen"
+	"golang.org/x/exp/shiny/screen"
+)
+
+var (
+	ErrUnsupportedTexture = errors.New("texture pixels cannot be read")
+	ErrNoFrames           = errors.New("no frames recorded")
+	ErrTooManyFrames      = errors.New("too many frames, the rest of the recording is dropped")
+)
+
+// DefaultMaxFrames is the default Recorder.MaxFrames. An 800x800 GIF frame
+// takes about 640 KB in memory until Close.
+const DefaultMaxFrames = 500
+
+// ConcatFile is the name of the ffmpeg concat script written next to a PNG
+// sequence. It lists the frames with their durations, so the sequence can
+// be turned into a video with "ffmpeg -f concat -i frames.ffconcat".
+const ConcatFile = "frames.ffconcat"
+
+// Recorder is a painter.Receiver that records every frame it gets. A frame
+// lasts until the next one arrives, or until Close for the last one.
+type Recorder struct {
+	// MaxFrames limits the number of frames kept in memory for a GIF. Frames
+	// over the limit are dropped, the last kept one lasts until Close, and
+	// Close returns ErrTooManyFrames. A frame equal to the previous one only
+	// makes the previous one longer and is not counted. Zero means no limit.
+	MaxFrames int
+
+	path string
+	gif  *os.File // Nil для послідовності PNG.
+	now  func() time.Time
+
+	mu        sync.Mutex
+	frames    []*image.Paletted
+	durations []time.Duration
+	last      time.Time // Час отримання останнього кадру.
+	closed    bool
+	full      bool // Досягнуто MaxFrames.
+	err       error
+}
+
+// New creates a recorder. A path ending with .gif gives an animated GIF
+// written on Close. Any other path is a directory for frame-000001.png,
+// frame-000002.png, ... and ConcatFile.
+func New(path string) (*Recorder, error) {
+	r := &Recorder{MaxFrames: DefaultMaxFrames, path: path, now: time.Now}
+	if strings.EqualFold(filepath.Ext(path), ".gif") {
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		r.gif = f
+	} else if err := os.MkdirAll(path, 0o755); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Path returns the file or directory the recorder writes to.
+func (r *Recorder) Path() string {
+	return r.path
+}
+
+func (r *Recorder) Update(t screen.Texture) {
+	img, ok := offscreen.Image(t)
+	if !ok {
+		r.mu.Lock()
+		r.fail(fmt.Errorf("%w: %T", ErrUnsupportedTexture, t))
+		r.mu.Unlock()
+		return
+	}
+	r.Add(img)
+}
+
+// Add records img as a frame shown from now on. The image may be changed
+// after Add returns.
+func (r *Recorder) Add(img image.Image) {
+	now := r.now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed || r.err != nil || r.full {
+		return
+	}
+	var frame *image.Paletted
+	if r.gif != nil {
+		frame = paletted(img)
+		if n := len(r.frames); n > 0 && samePixels(r.frames[n-1], frame) {
+			return
+		}
+		if r.MaxFrames > 0 && len(r.frames) >= r.MaxFrames {
+			r.full = true
+			return
+		}
+	}
+	if n := len(r.durations); n > 0 {
+		r.durations[n-1] = now.Sub(r.last)
+	}
+	r.last = now
+	r.durations = append(r.durations, 0)
+
+	if r.gif != nil {
+		r.frames = append(r.frames, frame)
+		return
+	}
+	if err := offscreen.WritePNG(filepath.Join(r.path, frameName(len(r.durations))), img); err != nil {
+		r.fail(err)
+	}
+}
+
+// Frames returns the number of recorded frames.
+func (r *Recorder) Frames() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.durations)
+}
+
+func (r *Recorder) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// Close finishes the recording and returns the first error met while
+// recording. It must be called after the recorder stops getting frames.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return r.err
+	}
+	r.closed = true
+	if n := len(r.durations); n > 0 {
+		r.durations[n-1] = r.now().Sub(r.last)
+	}
+
+	if r.gif != nil {
+		r.fail(r.writeGIF())
+		if err := r.gif.Close(); err != nil {
+			r.fail(err)
+		}
+		r.frames = nil
+		if r.full {
+			r.fail(fmt.Errorf("%w: limit %d", ErrTooManyFrames, r.MaxFrames))
+		}
+		return r.err
+	}
+	if len(r.durations) == 0 {
+		r.fail(ErrNoFrames)
+		return r.err
+	}
+	r.fail(os.WriteFile(filepath.Join(r.path, ConcatFile), []byte(r.concat()), 0o644))
+	return r.err
+}
+
+func (r *Recorder) writeGIF() error {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.frames) == 0 {
+		return ErrNoFrames
+	}
+	anim := &gif.GIF{Image: r.frames, Delay: make([]int, len(r.frames))}
+	for i, d := range r.durations {
+		// Затримка в GIF задається в сотих частках секунди.
+		anim.Delay[i] = max(int(d.Round(10*time.Millisecond)/(10*time.Millisecond)), 1)
+	}
+	return gif.EncodeAll(r.gif, anim)
+}
+
+// paletted converts the image to the Plan 9 palette. Frames have few
+// distinct colors, so the palette index is looked up once per color.
+func paletted(img image.Image) *image.Paletted {
+	p := image.NewPaletted(img.Bounds(), palette.Plan9)
+	src, ok := img.(*image.RGBA)
+	if !ok {
+		draw.Draw(p, p.Rect, img, img.Bounds().Min, draw.Src)
+		return p
+	}
+	index := make(map[color.RGBA]uint8)
+	for y := p.Rect.Min.Y; y < p.Rect.Max.Y; y++ {
+		for x := p.Rect.Min.X; x < p.Rect.Max.X; x++ {
+			c := src.RGBAAt(x, y)
+			i, ok := index[c]
+			if !ok {
+				i = uint8(p.Palette.Index(c))
+				index[c] = i
+			}
+			p.SetColorIndex(x, y, i)
+		}
+	}
+	return p
+}
+
+func samePixels(a, b *image.Paletted) bool {
+	return a.Rect == b.Rect && bytes.Equal(a.Pix, b.Pix)
+}
+
+func (r *Recorder) concat() string {
+	var sb strings.Builder
+	sb.WriteString("ffconcat version 1.0\n")
+	for i, d := range r.durations {
+		fmt.Fprintf(&sb, "file %s\nduration %.3f\n", frameName(i+1), d.Seconds())
+	}
+	// ffmpeg ігнорує тривалість останнього кадру, якщо він не повторений.
+	fmt.Fprintf(&sb, "file %s\n", frameName(len(r.durations)))
+	return sb.String()
+}
+
+func frameName(n int) string {
+	return fmt.Sprintf("frame-%06d.png", n)
+}
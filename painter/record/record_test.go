@@ -0,0 +1,171 @@
+package record
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/roman-mazur/architecture-lab-3/ui/offscreen"
+)
+
+// clock returns the times in order, one per call.
+func clock(ms ...int) func() time.Time {
+	start := time.Now()
+	return func() time.Time {
+		t := start.Add(time.Duration(ms[0]) * time.Millisecond)
+		ms = ms[1:]
+		return t
+	}
+}
+
+func texture(c color.Color) *offscreen.Texture {
+	t := offscreen.NewTexture(image.Pt(20, 20))
+	t.Fill(t.Bounds(), c, draw.Src)
+	return t
+}
+
+func TestGIF(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.gif")
+	r, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.now = clock(0, 500, 600, 1600)
+
+	r.Update(texture(color.White))
+	r.Update(texture(color.Black))
+	r.Update(texture(color.RGBA{G: 0xff, A: 0xff}))
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	anim, err := gif.DecodeAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{50, 10, 100}; len(anim.Delay) != 3 || anim.Delay[0] != want[0] || anim.Delay[1] != want[1] || anim.Delay[2] != want[2] {
+		t.Errorf("delays: have: %v, want: %v", anim.Delay, want)
+	}
+	if r, g, b, _ := anim.Image[2].At(5, 5).RGBA(); r != 0 || g != 0xffff || b != 0 {
+		t.Errorf("last frame color: have: %v, want green", anim.Image[2].At(5, 5))
+	}
+}
+
+func TestPNGSequence(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "frames")
+	r, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.now = clock(0, 40, 1040)
+
+	r.Update(texture(color.White))
+	r.Update(texture(color.Black))
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"frame-000001.png", "frame-000002.png"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(dir, ConcatFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "ffconcat version 1.0\nfile frame-000001.png\nduration 0.040\nfile frame-000002.png\nduration 1.000\nfile frame-000002.png\n"
+	if string(data) != want {
+		t.Errorf("concat file:\nhave: %q\nwant: %q", data, want)
+	}
+}
+
+func TestRecorderErrors(t *testing.T) {
+	r, err := New(filepath.Join(t.TempDir(), "empty.gif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != ErrNoFrames {
+		t.Errorf("Close() without frames err = %v, want: %v", err, ErrNoFrames)
+	}
+
+	r, err = New(filepath.Join(t.TempDir(), "frames"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Update(nil)
+	r.Update(texture(color.White))
+	if err := r.Close(); err == nil || r.Frames() != 0 {
+		t.Errorf("Close() err = %v with %d frames, want an error for an unreadable texture", err, r.Frames())
+	}
+}
+
+func decodeGIF(t *testing.T, path string) *gif.GIF {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	anim, err := gif.DecodeAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return anim
+}
+
+func TestGIFSameFrames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "same.gif")
+	r, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.now = clock(0, 100, 200, 300, 1000)
+
+	r.Update(texture(color.White))
+	r.Update(texture(color.White))
+	r.Update(texture(color.White))
+	r.Update(texture(color.Black))
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if r.Frames() != 2 {
+		t.Errorf("frames: have: %d, want: 2", r.Frames())
+	}
+	// Однакові кадри зливаються в один довший.
+	if anim := decodeGIF(t, path); len(anim.Delay) != 2 || anim.Delay[0] != 30 || anim.Delay[1] != 70 {
+		t.Errorf("delays: have: %v, want: [30 70]", anim.Delay)
+	}
+}
+
+func TestGIFMaxFrames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "long.gif")
+	r, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.MaxFrames = 2
+	r.now = clock(0, 100, 200, 500)
+
+	r.Update(texture(color.White))
+	r.Update(texture(color.Black))
+	r.Update(texture(color.White))
+	if err := r.Close(); !errors.Is(err, ErrTooManyFrames) {
+		t.Errorf("Close() err = %v, want: %v", err, ErrTooManyFrames)
+	}
+	// Записані до обмеження кадри зберігаються.
+	if anim := decodeGIF(t, path); len(anim.Delay) != 2 || anim.Delay[1] != 40 {
+		t.Errorf("delays: have: %v, want: [10 40]", anim.Delay)
+	}
+}
@@ -0,0 +1,82 @@
+package painter_test
+
+import (
+	"testing"
+
+	"github.com/roman-mazur/architecture-lab-3/painter/golden"
+)
+
+// Еталонні зображення лежать у testdata, оновлюються через -update.
+func TestRenderGolden(t *testing.T) {
+	cases := []struct {
+		name   string
+		script string
+	}{
+		{
+			name:   "background",
+			script: "green\ngreen\ngreen\nwhite\nupdate",
+		},
+		{
+			// Прямокутник з тим самим id замінює попередній на його місці,
+			// тому rect3 малюється поверх нового "a".
+			name: "bgrects-accumulate",
+			script: `green
+bgrect 0.1 0.1 0.2 0.2
+bgrect 0.2 0.2 0.3 0.3 white id=a
+bgrect 0.3 0.3 0.45 0.45
+bgrect 0.4 0.4 0.5 0.5 white id=a
+update`,
+		},
+		{
+			name:   "figure",
+			script: "white\nfigure 0.25 0.75\nupdate",
+		},
+		{
+			name:   "figure-corner",
+			script: "white\nfigure 0 0\nupdate",
+		},
+		{
+			name: "rect-and-figure",
+			script: `bgrect 0.3 0.3 0.7 0.7 white
+figure 0.5 0.5
+green
+update`,
+		},
+		{
+			name: "reset",
+			script: `figure 0.5 0.5
+bgrect 0 0 1 1 white
+white
+reset
+update`,
+		},
+		{
+			name: "pixels",
+			script: `fill #0000ff
+bgrect 0 0 0.25 0.25 white
+figure 0.5 0.5
+update`,
+		},
+		{
+			name: "move",
+			script: `white
+bgrect 0.1 0.1 0.3 0.3
+figure 0.2 0.2 id=t
+move t 0.7 0.7
+update`,
+		},
+		{
+			// Напівпрозорий прямокутник змішується з фоном і тим, що під ним.
+			name: "translucent-rect",
+			script: `white
+bgrect 0.1 0.1 0.6 0.6 #0000ff
+bgrect 0.4 0.4 0.9 0.9 #ff000080
+update`,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			golden.AssertScript(t, tc.name, tc.script)
+		})
+	}
+}
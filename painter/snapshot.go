@@ -0,0 +1,45 @@
+package painter
+
+import (
+	"context"
+	"image/color"
+)
+
+// Snapshot is a read-only copy of the loop state at some point in time.
+type Snapshot struct {
+	state *State
+}
+
+func (s Snapshot) Background() color.Color {
+	return s.state.Background()
+}
+
+// Rects returns a copy of the rectangles from bottom to top.
+func (s Snapshot) Rects() []RectItem {
+	return s.state.Rects()
+}
+
+// Figures returns a copy of the figures.
+func (s Snapshot) Figures() []FigureItem {
+	return s.state.Figures()
+}
+
+// State returns a copy of the snapshot as a mutable state.
+func (s Snapshot) State() *State {
+	return s.state.Clone()
+}
+
+// MarshalJSON encodes the snapshot with colors as hex strings.
+func (s Snapshot) MarshalJSON() ([]byte, error) {
+	return s.state.MarshalJSON()
+}
+
+// Snapshot returns a copy of the state after every operation queued before
+// the call in the same or a higher priority lane.
+func (l *Loop) Snapshot(ctx context.Context) (Snapshot, error) {
+	var snap Snapshot
+	if err := l.query(ctx, func() { snap = Snapshot{l.state.Clone()} }); err != nil {
+		return Snapshot{}, err
+	}
+	return snap, nil
+}
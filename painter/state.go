@@ -1,54 +1,200 @@
 package painter
 
 import (
+	"encoding/json"
 	"image"
 	"image/color"
 	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/roman-mazur/architecture-lab-3/ui"
 	"golang.org/x/exp/shiny/screen"
 )
 
-type textureState struct {
+// State is the scene that operations change and the loop renders: a
+// background color, rectangles over it and T figures on top.
+// Coordinates are relative to the texture size.
+type State struct {
 	background struct {
 		color color.Color
-		rect  *Rectangle
+		rects []RectItem // Прямокутники малюються в порядку додавання.
 	}
-	figures []Point
+	figures []FigureItem
+	// Номер останнього автоматичного ID фігури. Він лише зростає, тож ID
+	// видаленої фігури не дістанеться новій.
+	figureSeq int
 }
 
-func newTextureState() *textureState {
-	s := &textureState{}
+type RectItem struct {
+	ID    string
+	Rect  Rectangle
+	Color color.Color
+}
+
+type FigureItem struct {
+	ID  string
+	Pos Point
+}
+
+func NewState() *State {
+	s := &State{}
 	s.background.color = color.Black
 	return s
 }
 
-func (s *textureState) set(t screen.Texture) {
+// Clone returns a deep copy of the state.
+func (s *State) Clone() *State {
+	c := &State{figures: slices.Clone(s.figures), figureSeq: s.figureSeq}
+	c.background.color = s.background.color
+	c.background.rects = slices.Clone(s.background.rects)
+	return c
+}
+
+func (s *State) Background() color.Color {
+	return s.background.color
+}
+
+func (s *State) SetBackground(c color.Color) {
+	s.background.color = c
+}
+
+// Rects returns a copy of the rectangles from bottom to top.
+func (s *State) Rects() []RectItem {
+	return slices.Clone(s.background.rects)
+}
+
+func (s *State) Rect(id string) (RectItem, bool) {
+	i := s.rectIndex(id)
+	if i < 0 {
+		return RectItem{}, false
+	}
+	return s.background.rects[i], true
+}
+
+// AddRect puts the rectangle on top of the others. A rectangle with the
+// same non-empty ID is replaced in place.
+func (s *State) AddRect(r RectItem) {
+	if i := s.rectIndex(r.ID); r.ID != "" && i >= 0 {
+		s.background.rects[i] = r
+		return
+	}
+	s.background.rects = append(s.background.rects, r)
+}
+
+func (s *State) RemoveRect(id string) bool {
+	i := s.rectIndex(id)
+	if i < 0 {
+		return false
+	}
+	s.background.rects = slices.Delete(s.background.rects, i, i+1)
+	return true
+}
+
+func (s *State) ClearRects() {
+	s.background.rects = nil
+}
+
+func (s *State) rectIndex(id string) int {
+	return slices.IndexFunc(s.background.rects, func(r RectItem) bool {
+		return r.ID == id
+	})
+}
+
+// Figures returns a copy of the figures in drawing order.
+func (s *State) Figures() []FigureItem {
+	return slices.Clone(s.figures)
+}
+
+func (s *State) Figure(id string) (FigureItem, bool) {
+	i := s.figureIndex(id)
+	if i < 0 {
+		return FigureItem{}, false
+	}
+	return s.figures[i], true
+}
+
+// AddFigure places a new figure, or moves an existing one with the same ID,
+// and returns the figure ID. A figure without an ID gets the next one of
+// "f1", "f2", ... that is free; automatic IDs are never given out twice,
+// even after the figure is removed.
+func (s *State) AddFigure(f FigureItem) string {
+	if f.ID == "" {
+		for {
+			s.figureSeq++
+			f.ID = "f" + strconv.Itoa(s.figureSeq)
+			if s.figureIndex(f.ID) < 0 {
+				break
+			}
+		}
+	} else if i := s.figureIndex(f.ID); i >= 0 {
+		s.figures[i].Pos = f.Pos
+		return f.ID
+	}
+	s.figures = append(s.figures, f)
+	return f.ID
+}
+
+func (s *State) MoveFigure(id string, pos Point) bool {
+	i := s.figureIndex(id)
+	if i < 0 {
+		return false
+	}
+	s.figures[i].Pos = pos
+	return true
+}
+
+func (s *State) MoveAllFigures(pos Point) {
+	for i := range s.figures {
+		s.figures[i].Pos = pos
+	}
+}
+
+func (s *State) RemoveFigure(id string) bool {
+	i := s.figureIndex(id)
+	if i < 0 {
+		return false
+	}
+	s.figures = slices.Delete(s.figures, i, i+1)
+	return true
+}
+
+func (s *State) figureIndex(id string) int {
+	return slices.IndexFunc(s.figures, func(f FigureItem) bool {
+		return f.ID == id
+	})
+}
+
+// Reset returns the state to a black background without shapes.
+func (s *State) Reset() {
+	s.background.color = color.Black
+	s.background.rects = nil
+	s.figures = s.figures[:0]
+}
+
+// Render draws the state over the whole texture.
+func (s *State) Render(t screen.Texture) {
 	t.Fill(t.Bounds(), s.background.color, screen.Src)
-	if s.background.rect != nil {
-		t.Fill(s.background.rect.
+	for _, rect := range s.background.rects {
+		t.Fill(rect.Rect.
 			Resize(image.Pt(t.Bounds().Dx(), t.Bounds().Dy())).
 			ToImage().
-			Add(t.Bounds().Min), color.Black, screen.Src)
+			Add(t.Bounds().Min), rect.Color, screen.Over)
 	}
 	for _, figure := range s.figures {
-		ui.Figure(t, figure.
+		ui.Figure(t, figure.Pos.
 			Resize(image.Pt(t.Bounds().Dx(), t.Bounds().Dy())).
 			ToImage().
 			Add(t.Bounds().Min))
 	}
 }
 
-func (s1 textureState) Equal(s2 textureState) bool {
-	var isRectsEqual bool
-	rect1, rect2 := s1.background.rect, s2.background.rect
-	if rect1 != nil {
-		if rect2 != nil {
-			isRectsEqual = *rect1 == *rect2
-		}
-	} else {
-		isRectsEqual = rect1 == rect2
-	}
+func (s1 State) Equal(s2 State) bool {
+	isRectsEqual := slices.EqualFunc(s1.background.rects, s2.background.rects, func(r1, r2 RectItem) bool {
+		return r1.ID == r2.ID &&
+			r1.Rect == r2.Rect &&
+			isColorsEqual(r1.Color, r2.Color)
+	})
 
 	return isColorsEqual(s1.background.color, s2.background.color) &&
 		isRectsEqual &&
@@ -73,6 +219,74 @@ func isColorsEqual(c1, c2 color.Color) bool {
 	return false
 }
 
-func MockState() textureState {
-	return textureState{figures: []Point{{0, 0}}}
+func MockState() State {
+	return State{figures: []FigureItem{{"f1", Point{0, 0}}}}
+}
+
+type jsonState struct {
+	Background jsonColor    `json:"background"`
+	Rects      []jsonBgRect `json:"rects"`
+	Figures    []jsonFigure `json:"figures"`
+}
+
+// MarshalJSON encodes the state with colors as hex strings.
+func (s *State) MarshalJSON() ([]byte, error) {
+	v := jsonState{
+		Background: jsonColor{s.background.color},
+		Rects:      []jsonBgRect{},
+		Figures:    []jsonFigure{},
+	}
+	for _, r := range s.background.rects {
+		v.Rects = append(v.Rects, jsonBgRect{r.ID, r.Rect, jsonColor{r.Color}})
+	}
+	for _, f := range s.figures {
+		v.Figures = append(v.Figures, jsonFigure{f.ID, f.Pos})
+	}
+	return json.Marshal(v)
+}
+
+func (s *State) UnmarshalJSON(data []byte) error {
+	var v jsonState
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	res := NewState()
+	if v.Background.Color != nil {
+		res.background.color = v.Background.Color
+	}
+	for _, r := range v.Rects {
+		res.background.rects = append(res.background.rects, RectItem{r.ID, r.Rect, r.Color.Color})
+	}
+	for _, f := range v.Figures {
+		res.figures = append(res.figures, FigureItem{f.ID, f.Pos})
+		// Лічильник у JSON не пишеться, тож не повторюємо хоча б наявні ID.
+		if n, ok := autoFigureNumber(f.ID); ok {
+			res.figureSeq = max(res.figureSeq, n)
+		}
+	}
+	*s = *res
+	return nil
+}
+
+// FigureSeq returns the number of the last automatic figure ID given out.
+// It is not part of the JSON encoding, so it has to be kept separately to
+// never reuse IDs of removed figures.
+func (s *State) FigureSeq() int {
+	return s.figureSeq
+}
+
+// SetFigureSeq makes automatic figure IDs continue after "fN". The counter
+// never goes back.
+func (s *State) SetFigureSeq(n int) {
+	s.figureSeq = max(s.figureSeq, n)
+}
+
+// autoFigureNumber returns N for an ID of the form "fN".
+func autoFigureNumber(id string) (int, bool) {
+	rest, ok := strings.CutPrefix(id, "f")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(rest)
+	return n, err == nil && n > 0
 }
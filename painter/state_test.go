@@ -0,0 +1,120 @@
+package painter_test
+
+import (
+	"encoding/json"
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/roman-mazur/architecture-lab-3/painter"
+)
+
+// mirrorFigures is an operation defined outside of the painter package.
+type mirrorFigures struct{}
+
+func (mirrorFigures) Do(s *painter.State) (bool, error) {
+	for _, f := range s.Figures() {
+		s.MoveFigure(f.ID, painter.Pt(1-f.Pos.X, f.Pos.Y))
+	}
+	return false, nil
+}
+
+func TestCustomOperation(t *testing.T) {
+	s := painter.NewState()
+	_, err := painter.OperationList{
+		painter.NamedFigure("a", painter.Pt(0.2, 0.5)),
+		painter.Figure(painter.Pt(0.75, 0.25)),
+		mirrorFigures{},
+	}.Do(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []painter.FigureItem{
+		{ID: "a", Pos: painter.Pt(0.8, 0.5)},
+		{ID: "f1", Pos: painter.Pt(0.25, 0.25)},
+	}
+	have := s.Figures()
+	if len(have) != len(want) {
+		t.Fatalf("figures: have: %v, want: %v", have, want)
+	}
+	for i := range want {
+		if have[i] != want[i] {
+			t.Errorf("figure %d: have: %v, want: %v", i, have[i], want[i])
+		}
+	}
+}
+
+func TestStateAccessors(t *testing.T) {
+	s := painter.NewState()
+	s.SetBackground(color.White)
+	s.AddRect(painter.RectItem{ID: "a", Rect: painter.Rect(0, 0, 1, 1), Color: color.Black})
+	s.AddRect(painter.RectItem{ID: "b", Rect: painter.Rect(0, 0, 0.5, 0.5), Color: color.Black})
+	s.AddRect(painter.RectItem{ID: "a", Rect: painter.Rect(0.1, 0.1, 0.2, 0.2), Color: color.White})
+
+	if rects := s.Rects(); len(rects) != 2 || rects[0].Rect != painter.Rect(0.1, 0.1, 0.2, 0.2) {
+		t.Errorf("rect with the same ID is not replaced in place: %v", rects)
+	}
+	if !s.RemoveRect("b") || s.RemoveRect("b") {
+		t.Errorf("RemoveRect must report whether the rect existed")
+	}
+
+	s.Rects()[0].ID = "changed"
+	if _, ok := s.Rect("a"); !ok {
+		t.Errorf("Rects must return a copy")
+	}
+
+	if id := s.AddFigure(painter.FigureItem{}); id != "f1" {
+		t.Errorf("AddFigure() = %s, want: f1", id)
+	}
+	if s.MoveFigure("unknown", painter.Pt(1, 1)) {
+		t.Errorf("MoveFigure reported success for unknown figure")
+	}
+
+	s.Reset()
+	if len(s.Rects()) != 0 || len(s.Figures()) != 0 || s.Background() != color.Black {
+		t.Errorf("Reset() left state: %v, %v, %v", s.Background(), s.Rects(), s.Figures())
+	}
+}
+
+func TestFigureIDsAreNotReused(t *testing.T) {
+	s := painter.NewState()
+	s.AddFigure(painter.FigureItem{})
+	s.AddFigure(painter.FigureItem{ID: "f3"})
+	s.RemoveFigure("f1")
+	var ids []string
+	for range 2 {
+		ids = append(ids, s.AddFigure(painter.FigureItem{}))
+	}
+	// f1 видалено, а f3 зайнято іменованою фігурою.
+	if ids[0] != "f2" || ids[1] != "f4" {
+		t.Errorf("automatic IDs: have: %v, want: [f2 f4]", ids)
+	}
+
+	// Лічильник не входить у JSON, його передають окремо.
+	s.RemoveFigure("f4")
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "figureSeq") {
+		t.Errorf("JSON has the figure counter: %s", data)
+	}
+	var restored painter.State
+	if err := json.Unmarshal(data, &restored); err != nil {
+		t.Fatal(err)
+	}
+	restored.SetFigureSeq(s.FigureSeq())
+	if id := restored.AddFigure(painter.FigureItem{}); id != "f5" {
+		t.Errorf("ID after JSON round trip: have: %s, want: f5", id)
+	}
+
+	// Стан без лічильника продовжує нумерацію після наявних фігур.
+	var old painter.State
+	if err := json.Unmarshal([]byte(`{"background":"#000000","rects":[],"figures":[{"id":"f7","pos":{"x":0,"y":0}}]}`), &old); err != nil {
+		t.Fatal(err)
+	}
+	if id := old.AddFigure(painter.FigureItem{}); id != "f8" {
+		t.Errorf("ID after a state without the counter: have: %s, want: f8", id)
+	}
+}
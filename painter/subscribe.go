@@ -0,0 +1,226 @@
+package painter
+
+import (
+	"runtime/debug"
+	"sync"
+
+	"golang.org/x/exp/shiny/screen"
+)
+
+// DeliveryPolicy defines how frames reach a subscribed receiver.
+type DeliveryPolicy int
+
+const (
+	// Blocking calls Update from the loop goroutine, so a slow receiver
+	// slows down the loop.
+	Blocking DeliveryPolicy = iota
+	// LatestWins calls Update from a separate goroutine. A frame waiting
+	// for the receiver is replaced by a newer one.
+	LatestWins
+	// Drop calls Update from a separate goroutine and drops new frames
+	// while the receiver is busy.
+	Drop
+)
+
+// Subscribe adds a receiver of frames. Every subscriber gets the same
+// texture and must only read it. The texture stays valid until the
+// receiver's next Update call returns or the receiver is unsubscribed.
+// The receiver must be comparable, such as a pointer, to be unsubscribed.
+// After the loop has stopped Subscribe returns ErrLoopStopped.
+func (l *Loop) Subscribe(r Receiver, p DeliveryPolicy) error {
+	sub := &subscriber{r: r, policy: p, pool: &l.pool}
+	sub.idle = sync.NewCond(&sub.mu)
+	sub.panicked = func(err error) {
+		if p == Blocking {
+			l.report(err) // Update викликано з горутини циклу.
+			return
+		}
+		// Помилки повідомляються з горутини циклу, тож паніку передаємо туди.
+		if l.mq.tryPush(prioritized{receiverPanic{err}, PriorityControl}) != nil {
+			l.setErr(err)
+		}
+	}
+
+	l.subsMu.Lock()
+	defer l.subsMu.Unlock()
+	if l.subsDone {
+		return ErrLoopStopped
+	}
+	if p != Blocking {
+		sub.wake = make(chan struct{}, 1)
+		sub.done = make(chan struct{})
+		sub.exited = make(chan struct{})
+		go sub.run()
+	}
+	l.subs = append(l.subs, sub)
+	return nil
+}
+
+// Unsubscribe stops delivering frames to the receiver and waits until its
+// pending Update call returns. It must not be called from that Update.
+func (l *Loop) Unsubscribe(r Receiver) {
+	l.subsMu.Lock()
+	var sub *subscriber
+	for i, s := range l.subs {
+		if s.r == r {
+			sub = s
+			l.subs = append(l.subs[:i:i], l.subs[i+1:]...)
+			break
+		}
+	}
+	l.subsMu.Unlock()
+	if sub != nil {
+		sub.close()
+	}
+}
+
+func (l *Loop) subscribers() []*subscriber {
+	l.subsMu.Lock()
+	defer l.subsMu.Unlock()
+	return l.subs
+}
+
+func (l *Loop) unsubscribeAll() {
+	l.subsMu.Lock()
+	subs := l.subs
+	l.subs, l.subsDone = nil, true
+	l.subsMu.Unlock()
+	for _, sub := range subs {
+		sub.close()
+	}
+}
+
+type subscriber struct {
+	r      Receiver
+	policy DeliveryPolicy
+	pool   *texturePool
+
+	mu      sync.Mutex
+	current screen.Texture // Останній показаний кадр.
+	pending screen.Texture // Кадр, що чекає на виклик Update.
+	busy    bool           // Виконується Update.
+	closed  bool
+	idle    *sync.Cond // Сигналізує, що busy скинуто.
+
+	panicked func(err error)
+
+	wake   chan struct{}
+	done   chan struct{}
+	exited chan struct{}
+}
+
+// deliver is called from the loop goroutine.
+func (s *subscriber) deliver(t screen.Texture) {
+	if s.policy == Blocking {
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			return
+		}
+		s.busy = true
+		s.mu.Unlock()
+
+		s.pool.retain(t)
+		s.update(t)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	if s.pending != nil {
+		if s.policy == Drop {
+			return
+		}
+		s.pool.put(s.pending)
+	} else if s.busy && s.policy == Drop {
+		return
+	}
+	s.pool.retain(t)
+	s.pending = t
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// update calls Update of the receiver, which must be marked busy. Even if
+// Update panics, t becomes the current texture and the receiver becomes
+// idle, and the panic is passed to s.panicked as *PanicError.
+func (s *subscriber) update(t screen.Texture) {
+	defer func() {
+		v := recover()
+		s.shown(t)
+		s.mu.Lock()
+		s.busy = false
+		s.idle.Broadcast()
+		s.mu.Unlock()
+		if v != nil {
+			s.panicked(&PanicError{Value: v, Stack: debug.Stack()})
+		}
+	}()
+	s.r.Update(t)
+}
+
+// receiverPanic carries a panic of an asynchronous receiver to the loop
+// goroutine, which reports it.
+type receiverPanic struct{ err error }
+
+// shown makes t the current texture of the receiver and releases the
+// previous one.
+func (s *subscriber) shown(t screen.Texture) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		s.pool.put(t)
+		return
+	}
+	if s.current != nil {
+		s.pool.put(s.current)
+	}
+	s.current = t
+}
+
+func (s *subscriber) run() {
+	defer close(s.exited)
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.wake:
+		}
+
+		s.mu.Lock()
+		t := s.pending
+		s.pending = nil
+		s.busy = t != nil
+		s.mu.Unlock()
+		if t == nil {
+			continue
+		}
+
+		s.update(t)
+	}
+}
+
+func (s *subscriber) close() {
+	if s.done != nil {
+		close(s.done)
+		<-s.exited
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	// Отримувач Blocking може бути зайнятий кадром у горутині циклу.
+	for s.busy {
+		s.idle.Wait()
+	}
+	for _, t := range []screen.Texture{s.pending, s.current} {
+		if t != nil {
+			s.pool.put(t)
+		}
+	}
+	s.pending, s.current = nil, nil
+}
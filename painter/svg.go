@@ -0,0 +1,72 @@
+package painter
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"io"
+	"strconv"
+
+	"github.com/roman-mazur/architecture-lab-3/ui"
+)
+
+// WriteSVG writes the state as an SVG image of the given size. Shapes keep
+// exact coordinates instead of being rounded to pixels. T figures are made
+// of two rectangles with the proportions used by ui.Figure.
+func (s *State) WriteSVG(w io.Writer, size image.Point) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n",
+		size.X, size.Y, size.X, size.Y)
+	writeSVGRect(bw, "", 0, 0, float32(size.X), float32(size.Y), s.background.color)
+
+	for _, r := range s.background.rects {
+		rect := r.Rect.Resize(size)
+		id := ""
+		if r.ID != "" {
+			id = "rect-" + r.ID
+		}
+		writeSVGRect(bw, id, rect.Min.X, rect.Min.Y, rect.Max.X-rect.Min.X, rect.Max.Y-rect.Min.Y, r.Color)
+	}
+
+	// Пропорції такі ж, як у ui.drawTFigure.
+	var (
+		horizW = float32(size.X) / 2
+		horizH = float32(size.Y) / 6
+		vertW  = float32(size.X) / 6
+		vertH  = float32(size.Y) / 4
+	)
+	for _, f := range s.figures {
+		pos := f.Pos.Resize(size)
+		fmt.Fprintf(bw, `<g id="%s">`+"\n", html.EscapeString("figure-"+f.ID))
+		writeSVGRect(bw, "", pos.X-horizW/2, pos.Y, horizW, horizH, ui.Yellow)
+		writeSVGRect(bw, "", pos.X-vertW/2, pos.Y-vertH, vertW, vertH, ui.Yellow)
+		bw.WriteString("</g>\n")
+	}
+
+	bw.WriteString("</svg>\n")
+	return bw.Flush()
+}
+
+func writeSVGRect(w *bufio.Writer, id string, x, y, width, height float32, c color.Color) {
+	if width <= 0 || height <= 0 {
+		return // Як і image.Rectangle, прямокутник з min > max порожній.
+	}
+	w.WriteString("<rect")
+	if id != "" {
+		fmt.Fprintf(w, ` id="%s"`, html.EscapeString(id))
+	}
+	fmt.Fprintf(w, ` x="%s" y="%s" width="%s" height="%s"`, svgNum(x), svgNum(y), svgNum(width), svgNum(height))
+
+	nc := color.NRGBAModel.Convert(c).(color.NRGBA)
+	fmt.Fprintf(w, ` fill="#%02x%02x%02x"`, nc.R, nc.G, nc.B)
+	if nc.A != 0xff {
+		fmt.Fprintf(w, ` fill-opacity="%s"`, strconv.FormatFloat(float64(nc.A)/0xff, 'g', 3, 64))
+	}
+	w.WriteString("/>\n")
+}
+
+func svgNum(f float32) string {
+	return strconv.FormatFloat(float64(f), 'g', -1, 32)
+}
@@ -0,0 +1,33 @@
+package painter
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestWriteSVG(t *testing.T) {
+	s := NewState()
+	s.SetBackground(color.White)
+	s.AddRect(RectItem{"top", Rect(0, 0, 0.5, 0.25), color.NRGBA{R: 0xff, A: 0x80}})
+	s.AddRect(RectItem{"", Rect(0.5, 0.5, 0.4, 0.6), color.Black}) // Порожній прямокутник.
+	s.AddFigure(FigureItem{Pos: Pt(0.5, 0.5)})
+
+	var sb strings.Builder
+	if err := s.WriteSVG(&sb, image.Pt(600, 600)); err != nil {
+		t.Fatal(err)
+	}
+	want := `<svg xmlns="http://www.w3.org/2000/svg" width="600" height="600" viewBox="0 0 600 600">
+<rect x="0" y="0" width="600" height="600" fill="#ffffff"/>
+<rect id="rect-top" x="0" y="0" width="300" height="150" fill="#ff0000" fill-opacity="0.502"/>
+<g id="figure-f1">
+<rect x="150" y="300" width="300" height="100" fill="#ffc864"/>
+<rect x="250" y="150" width="100" height="150" fill="#ffc864"/>
+</g>
+</svg>
+`
+	if sb.String() != want {
+		t.Errorf("WriteSVG():\nhave:\n%s\nwant:\n%s", sb.String(), want)
+	}
+}
@@ -13,7 +13,7 @@ func main() {
 	// Крок переміщення
 	step := 0.05
 
-	initial := fmt.Sprintf("white\nfigure %.2f %.2f\nupdate\n", x, y)
+	initial := fmt.Sprintf("white\nfigure %.2f %.2f id=diagonal\nupdate\n", x, y)
 	_, _ = http.Post("http://localhost:17000", "text/plain", bytes.NewBufferString(initial))
 
 	time.Sleep(1 * time.Second)
@@ -27,7 +27,7 @@ func main() {
 			break
 		}
 
-		script := fmt.Sprintf("move %.2f %.2f\nupdate\n", x, y)
+		script := fmt.Sprintf("move diagonal %.2f %.2f\nupdate\n", x, y)
 		resp, err := http.Post("http://localhost:17000", "text/plain", bytes.NewBufferString(script))
 		if err != nil {
 			fmt.Println("Error:", err)
@@ -0,0 +1,70 @@
+package offscreen
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/exp/shiny/screen"
+)
+
+// FrameStore receives rendered textures, keeps a copy of the latest one in
+// memory and optionally writes every frame to Dir as a PNG file.
+type FrameStore struct {
+	Dir string
+
+	mu  sync.Mutex
+	img *image.RGBA
+	n   uint64
+}
+
+func (fs *FrameStore) Update(t screen.Texture) {
+	src, ok := Image(t)
+	if !ok {
+		return
+	}
+	img := cloneRGBA(src)
+
+	fs.mu.Lock()
+	fs.n++
+	n := fs.n
+	fs.img = img
+	fs.mu.Unlock()
+
+	if fs.Dir != "" {
+		if err := WritePNG(filepath.Join(fs.Dir, fmt.Sprintf("frame-%06d.png", n)), img); err != nil {
+			log.Printf("Failed to write frame %d: %s", n, err)
+		}
+	}
+}
+
+// Latest returns the last received frame and its number, starting from 1.
+// The number is 0 if no frame has been received yet.
+func (fs *FrameStore) Latest() (*image.RGBA, uint64) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.img, fs.n
+}
+
+func cloneRGBA(src *image.RGBA) *image.RGBA {
+	dst := image.NewRGBA(src.Rect)
+	copy(dst.Pix, src.Pix)
+	return dst
+}
+
+// WritePNG writes img to a new file at path.
+func WritePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,56 @@
+package offscreen
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	"golang.org/x/exp/shiny/screen"
+)
+
+// Mirror wraps a screen so that every texture it creates also keeps an
+// offscreen copy of its pixels. The copy is available through Image, and the
+// wrapped texture through Unwrap.
+func Mirror(s screen.Screen) screen.Screen {
+	return mirrorScreen{s}
+}
+
+type mirrorScreen struct {
+	screen.Screen
+}
+
+func (s mirrorScreen) NewTexture(size image.Point) (screen.Texture, error) {
+	t, err := s.Screen.NewTexture(size)
+	if err != nil {
+		return nil, err
+	}
+	return &mirrorTexture{Texture: t, shadow: NewTexture(size)}, nil
+}
+
+type mirrorTexture struct {
+	screen.Texture
+	shadow *Texture
+}
+
+func (t *mirrorTexture) Upload(dp image.Point, src screen.Buffer, sr image.Rectangle) {
+	t.Texture.Upload(dp, src, sr)
+	t.shadow.Upload(dp, src, sr)
+}
+
+func (t *mirrorTexture) Fill(dr image.Rectangle, src color.Color, op draw.Op) {
+	t.Texture.Fill(dr, src, op)
+	t.shadow.Fill(dr, src, op)
+}
+
+func (t *mirrorTexture) Unwrap() screen.Texture { return t.Texture }
+
+// Image returns the pixels of a texture created by Screen or Mirror.
+func Image(t screen.Texture) (*image.RGBA, bool) {
+	switch t := t.(type) {
+	case *Texture:
+		return t.RGBA(), true
+	case *mirrorTexture:
+		return t.shadow.RGBA(), true
+	}
+	return nil, false
+}
@@ -0,0 +1,66 @@
+// Package offscreen implements screen.Screen in pure Go on top of image.RGBA,
+// so the painter can render without a display.
+package offscreen
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+
+	"golang.org/x/exp/shiny/screen"
+)
+
+var ErrNoWindow = errors.New("offscreen: windows are not supported")
+
+type Screen struct{}
+
+func (Screen) NewBuffer(size image.Point) (screen.Buffer, error) {
+	return &Buffer{rgba: image.NewRGBA(image.Rectangle{Max: size})}, nil
+}
+
+func (Screen) NewTexture(size image.Point) (screen.Texture, error) {
+	return NewTexture(size), nil
+}
+
+func (Screen) NewWindow(opts *screen.NewWindowOptions) (screen.Window, error) {
+	return nil, ErrNoWindow
+}
+
+type Buffer struct {
+	rgba *image.RGBA
+}
+
+func (b *Buffer) Release() {}
+
+func (b *Buffer) Size() image.Point { return b.rgba.Rect.Size() }
+
+func (b *Buffer) Bounds() image.Rectangle { return b.rgba.Rect }
+
+func (b *Buffer) RGBA() *image.RGBA { return b.rgba }
+
+type Texture struct {
+	rgba *image.RGBA
+}
+
+func NewTexture(size image.Point) *Texture {
+	return &Texture{rgba: image.NewRGBA(image.Rectangle{Max: size})}
+}
+
+func (t *Texture) Release() {}
+
+func (t *Texture) Size() image.Point { return t.rgba.Rect.Size() }
+
+func (t *Texture) Bounds() image.Rectangle { return t.rgba.Rect }
+
+func (t *Texture) Upload(dp image.Point, src screen.Buffer, sr image.Rectangle) {
+	dr := sr.Sub(sr.Min).Add(dp)
+	draw.Draw(t.rgba, dr, src.RGBA(), sr.Min, draw.Src)
+}
+
+func (t *Texture) Fill(dr image.Rectangle, src color.Color, op draw.Op) {
+	draw.Draw(t.rgba, dr, image.NewUniform(src), image.Point{}, op)
+}
+
+// RGBA returns the texture pixels. The image is shared with the texture.
+func (t *Texture) RGBA() *image.RGBA { return t.rgba }
@@ -0,0 +1,116 @@
+package offscreen
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/roman-mazur/architecture-lab-3/ui"
+	"golang.org/x/exp/shiny/screen"
+)
+
+func TestTextureFill(t *testing.T) {
+	tx, err := Screen{}.NewTexture(image.Pt(10, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx.Fill(tx.Bounds(), color.White, draw.Src)
+	tx.Fill(image.Rect(2, 2, 4, 4), color.Black, draw.Src)
+
+	img := tx.(*Texture).RGBA()
+	if c := img.RGBAAt(0, 0); c != (color.RGBA{0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("background pixel: have: %v, want white", c)
+	}
+	if c := img.RGBAAt(3, 3); c != (color.RGBA{A: 0xff}) {
+		t.Errorf("rect pixel: have: %v, want black", c)
+	}
+	if c := img.RGBAAt(4, 4); c != (color.RGBA{0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("pixel outside rect: have: %v, want white", c)
+	}
+}
+
+func TestTextureUpload(t *testing.T) {
+	s := Screen{}
+	b, _ := s.NewBuffer(image.Pt(2, 2))
+	draw.Draw(b.RGBA(), b.Bounds(), image.NewUniform(ui.Green), image.Point{}, draw.Src)
+
+	tx := NewTexture(image.Pt(10, 10))
+	tx.Upload(image.Pt(5, 5), b, b.Bounds())
+
+	if c := tx.RGBA().RGBAAt(6, 6); c != ui.Green {
+		t.Errorf("uploaded pixel: have: %v, want: %v", c, ui.Green)
+	}
+	if c := tx.RGBA().RGBAAt(4, 4); c != (color.RGBA{}) {
+		t.Errorf("pixel outside upload: have: %v, want transparent", c)
+	}
+}
+
+func TestFigure(t *testing.T) {
+	tx := NewTexture(image.Pt(600, 600))
+	ui.Figure(tx, image.Pt(300, 300))
+
+	img := tx.RGBA()
+	// Горизонтальна та вертикальна частини фігури.
+	for _, p := range []image.Point{{300, 350}, {160, 310}, {300, 200}} {
+		if c := img.RGBAAt(p.X, p.Y); c != ui.Yellow {
+			t.Errorf("pixel %v: have: %v, want: %v", p, c, ui.Yellow)
+		}
+	}
+	if c := img.RGBAAt(100, 200); c != (color.RGBA{}) {
+		t.Errorf("pixel outside figure: have: %v, want transparent", c)
+	}
+}
+
+func TestNoWindow(t *testing.T) {
+	if _, err := (Screen{}).NewWindow(nil); err != ErrNoWindow {
+		t.Errorf("NewWindow() err = %v, want: %v", err, ErrNoWindow)
+	}
+}
+
+func TestFrameStore(t *testing.T) {
+	fs := FrameStore{Dir: t.TempDir()}
+	if img, n := fs.Latest(); img != nil || n != 0 {
+		t.Fatalf("Latest() = %v, %d before any frame", img, n)
+	}
+
+	tx := NewTexture(image.Pt(4, 4))
+	tx.Fill(tx.Bounds(), color.White, draw.Src)
+	fs.Update(tx)
+	tx.Fill(tx.Bounds(), color.Black, draw.Src)
+
+	img, n := fs.Latest()
+	if n != 1 {
+		t.Errorf("frame number: have: %d, want: 1", n)
+	}
+	if c := img.RGBAAt(0, 0); c != (color.RGBA{0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("stored frame changed with texture: have: %v, want white", c)
+	}
+	if _, err := os.Stat(filepath.Join(fs.Dir, "frame-000001.png")); err != nil {
+		t.Errorf("frame file was not written: %s", err)
+	}
+}
+
+func TestMirror(t *testing.T) {
+	s := Mirror(Screen{})
+	tx, err := s.NewTexture(image.Pt(4, 4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Fill(tx.Bounds(), color.White, draw.Src)
+
+	img, ok := Image(tx)
+	if !ok {
+		t.Fatal("Image() does not support mirrored textures")
+	}
+	if c := img.RGBAAt(1, 1); c != (color.RGBA{0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("shadow pixel: have: %v, want white", c)
+	}
+	inner := tx.(interface{ Unwrap() screen.Texture }).Unwrap()
+	if c := inner.(*Texture).RGBA().RGBAAt(1, 1); c != (color.RGBA{0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("wrapped texture pixel: have: %v, want white", c)
+	}
+}
@@ -19,8 +19,8 @@ import (
 const WindowSide = 800
 
 var (
-	Green  = color.RGBA{R: 100, G: 200, B: 100}
-	Yellow = color.RGBA{R: 255, G: 200, B: 100}
+	Green  = color.RGBA{R: 100, G: 200, B: 100, A: 0xff}
+	Yellow = color.RGBA{R: 255, G: 200, B: 100, A: 0xff}
 )
 
 type Visualizer struct {
@@ -138,6 +138,9 @@ func (pw *Visualizer) handleEvent(e any, t screen.Texture) {
 			pw.drawDefaultUI()
 		} else {
 			// Використання текстури отриманої через виклик Update.
+			if u, ok := t.(interface{ Unwrap() screen.Texture }); ok {
+				t = u.Unwrap()
+			}
 			pw.w.Scale(pw.sz.Bounds(), t, t.Bounds(), draw.Src, nil)
 		}
 		pw.w.Publish()